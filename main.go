@@ -1,19 +1,44 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/yannh/redis-dump-go/redisdump"
 )
 
+// stringsFlag is a flag that can be set several times
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
 func drawProgressBar(to io.Writer, currentPosition, nElements, widgetSize int) {
+	if nElements == 0 {
+		return
+	}
 	percent := currentPosition * 100 / nElements
 	nBars := widgetSize * percent / 100
 
@@ -22,18 +47,132 @@ func drawProgressBar(to io.Writer, currentPosition, nElements, widgetSize int) {
 	fmt.Fprintf(to, "\r[%s%s] %3d%% [%d/%d]", bars, spaces, int(percent), currentPosition, nElements)
 }
 
+// tlsConfig builds the TLS configuration used to connect to Redis. caCert is
+// an optional PEM bundle of CAs to verify the server against, instead of the
+// system pool. cert and key, if set, are used for client authentication.
+func tlsConfig(caCert, cert, key string, insecure bool) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: insecure}
+
+	if caCert != "" {
+		pem, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", caCert)
+		}
+	}
+
+	if cert != "" || key != "" {
+		clientCert, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{clientCert}
+	}
+
+	return config, nil
+}
+
+// printStats writes the statistics of a dry run, or a dump with
+// -verboseStats, to w
+func printStats(w io.Writer, stats redisdump.DumpStats) {
+	types := make([]string, 0, len(stats.ByType))
+	for keyType := range stats.ByType {
+		types = append(types, keyType)
+	}
+	sort.Strings(types)
+
+	fmt.Fprintf(w, "Keys: %d\n", stats.TotalKeys)
+	for _, keyType := range types {
+		fmt.Fprintf(w, "  %s: %d\n", keyType, stats.ByType[keyType])
+	}
+	fmt.Fprintf(w, "Keys with a TTL: %d\n", stats.TTLs)
+	fmt.Fprintf(w, "Skipped keys: %d\n", stats.SkippedKeys)
+	if stats.UnsupportedKeys > 0 {
+		fmt.Fprintf(w, "  of unsupported types: %d\n", stats.UnsupportedKeys)
+	}
+	fmt.Fprintf(w, "Memory usage: %d bytes\n", stats.MemoryUsage)
+	fmt.Fprintf(w, "Smallest key: %d bytes\n", stats.MinKeyBytes)
+	fmt.Fprintf(w, "Largest key: %d bytes\n", stats.MaxKeyBytes)
+	if len(stats.TopKeys) > 0 {
+		fmt.Fprintf(w, "Largest keys:\n")
+	}
+	for _, key := range stats.TopKeys {
+		fmt.Fprintf(w, "  db%d %s: %d bytes\n", key.DB, key.Key, key.Bytes)
+	}
+}
+
 func realMain() int {
 	var err error
 
-	// TODO: Number of workers & TTL as parameters
 	host := flag.String("host", "127.0.0.1", "Server host")
 	port := flag.Int("port", 6379, "Server port")
+	socket := flag.String("socket", "", "Server Unix socket path (overrides -host and -port)")
+	serverURL := flag.String("url", "", "Server URL, such as redis://user@host:6379/0, or rediss:// for TLS (overrides -host and -port)")
+	username := flag.String("user", "", "Username for Redis 6+ ACL authentication")
 	nWorkers := flag.Int("n", 10, "Parallel workers")
-	output := flag.String("output", "resp", "Output type - can be resp or commands")
+	cluster := flag.Bool("cluster", false, "Dump all the primaries of the Redis Cluster the server is part of")
+	var dbFlags, filters, excludeFilters, types, excludeTypes, sentinels stringsFlag
+	flag.Var(&dbFlags, "db", "Only dump the DB of this index, can be repeated (default all DBs)")
+	maxDB := flag.Int("maxDB", 0, "Index of the last DB of the server, when it does not allow CONFIG GET databases")
+	flag.Var(&filters, "filter", "Only dump keys matching this glob-style pattern, can be repeated (default \"*\")")
+	flag.Var(&excludeFilters, "exclude", "Do not dump keys matching this glob-style pattern, can be repeated")
+	flag.Var(&sentinels, "sentinel", "Address of a Sentinel to ask the master to dump to, can be repeated (overrides -host and -port)")
+	masterName := flag.String("masterName", "mymaster", "Name of the master to dump, with -sentinel")
+	flag.Var(&types, "type", "Only dump keys of this type, such as hash, can be repeated (default all types)")
+	flag.Var(&excludeTypes, "excludeType", "Do not dump keys of this type, such as stream, can be repeated")
+	keyRegex := flag.String("regex", "", "Only dump keys matching this regular expression")
+	excludeRegex := flag.String("excludeRegex", "", "Do not dump keys matching this regular expression")
+	batchSize := flag.Int("batchSize", 100, "Number of keys handed to a worker at once")
+	transactionSize := flag.Int("transactionSize", 0, "Wrap the commands of each batch in MULTI/EXEC transactions of at most this number of commands (default none)")
+	pipeline := flag.Bool("pipeline", false, "Read the keys of each batch in a few round trips")
+	poolSize := flag.Int("poolSize", 0, "Number of connections to the server per DB (default -n, at least 5)")
+	scanCount := flag.Int("scanCount", 100, "COUNT hint passed to each SCAN call")
+	output := flag.String("output", "resp", "Output type - can be resp, commands, json or resp3")
+	compression := flag.String("compress", "", "Compress the output - can be gzip")
 	silent := flag.Bool("s", false, "Silent mode (disable progress bar)")
+	largeKeyThreshold := flag.Int("largeKeyThreshold", 512, "Read keys with more elements than this incrementally, -1 to disable")
+	largeKeyBatchSize := flag.Int("largeKeyBatchSize", 1000, "Maximum number of elements restored by each command for large keys")
+	restoreHLL := flag.Bool("restoreHLL", false, "Dump HyperLogLogs with DUMP and RESTORE rather than SET")
+	dumpRestore := flag.Bool("dumpRestore", false, "Dump all keys with DUMP and RESTORE, keeping their exact encoding")
+	evictionInfo := flag.Bool("evictionInfo", false, "Restore the LRU idle time or LFU frequency of keys, with -dumpRestore")
+	encoding := flag.Bool("encoding", false, "Add the internal encoding of keys to the json output")
+	omitSelectForDB0 := flag.Bool("omitSelectForDB0", false, "Leave out the SELECT 0 before the keys of DB 0, when it is dumped first")
+	flushDB := flag.Bool("flushDB", false, "Empty each DB with FLUSHDB when restoring the dump, before restoring its keys")
+	rateLimit := flag.Float64("rateLimit", 0, "Maximum number of keys dumped per second, 0 for unlimited")
+	maxOpsPerSec := flag.Float64("maxOpsPerSec", 0, "Maximum number of commands sent to the server per second, 0 for unlimited")
+	maxRetries := flag.Int("maxRetries", 0, "Number of times commands and connections failing with a connection error are retried")
+	retryBackoff := flag.Duration("retryBackoff", 100*time.Millisecond, "Time to wait before the first retry, doubled for each of the next ones, with -maxRetries")
+	skipUnsupported := flag.Bool("skipUnsupported", false, "Skip keys of types that can not be dumped, such as module types, with a warning, rather than failing")
+	strict := flag.Bool("strict", false, "Fail on keys deleted while they are dumped, rather than skipping them with a warning")
+	waitReplicas := flag.Int("waitReplicas", 0, "Wait for this number of replicas with WAIT before dumping")
+	waitTimeout := flag.Duration("waitTimeout", time.Second, "Maximum time to wait for replicas, with -waitReplicas, 0 for no limit")
+	strictWait := flag.Bool("strictWait", false, "Fail when fewer replicas than -waitReplicas acknowledge in time, rather than warning")
+	continueOnError := flag.Bool("continueOnError", false, "Carry on with the next key when a key can not be dumped")
+	dryRun := flag.Bool("dryRun", false, "Only count the keys that would be dumped, by type, with their memory usage")
+	verboseStats := flag.Bool("verboseStats", false, "Measure the memory usage of keys, and print statistics to stderr once dumped")
+	memoryReport := flag.String("memoryReport", "", "Write the memory usage of every key dumped to this file, largest first")
+	topKeys := flag.Int("topKeys", 0, "Report this number of largest keys, by memory usage, with the statistics")
+	noTTL := flag.Bool("noTTL", false, "Do not dump the TTL of keys")
+	onlyVolatile := flag.Bool("onlyVolatile", false, "Only dump keys with an expiry")
+	onlyPersistent := flag.Bool("onlyPersistent", false, "Only dump keys without expiry")
+	skipExpired := flag.Bool("skipExpired", false, "Do not dump keys expiring while they are dumped, rather than dumping them without expiry")
+	parallelDBs := flag.Bool("parallelDBs", false, "Dump all DBs at the same time, with -n workers each")
+	maxParallelDBs := flag.Int("maxParallelDBs", 0, "Dump up to this number of DBs at the same time (implies -parallelDBs)")
+	expireTime := flag.Bool("expireTime", false, "Dump the absolute expiry of keys with PEXPIRETIME, requires Redis 7")
+	pttl := flag.Bool("pttl", false, "Dump TTLs with a millisecond precision, using PTTL and PEXPIREAT")
+	useTLS := flag.Bool("tls", false, "Connect to the server using TLS")
+	caCert := flag.String("cacert", "", "CA certificate file to verify the server with (implies -tls)")
+	cert := flag.String("cert", "", "Client certificate file to authenticate with (implies -tls)")
+	key := flag.String("key", "", "Client private key file to authenticate with (implies -tls)")
+	insecure := flag.Bool("insecure", false, "Skip verification of the server certificate (implies -tls)")
 	flag.Parse()
 
 	var serializer func([]string) string
+	var keyDumpSerializer func(redisdump.KeyDump) string
 	switch *output {
 	case "resp":
 		serializer = redisdump.RESPSerializer
@@ -41,8 +180,48 @@ func realMain() int {
 	case "commands":
 		serializer = redisdump.RedisCmdSerializer
 
+	case "json":
+		serializer = redisdump.RESPSerializer
+		keyDumpSerializer = redisdump.JSONSerializer
+
+	case "resp3":
+		serializer = redisdump.RESPSerializer
+		keyDumpSerializer = redisdump.RESP3Serializer
+
 	default:
-		log.Fatalf("Failed parsing parameter flag: can only be resp or json")
+		log.Fatalf("Failed parsing parameter flag: can only be resp, commands, json or resp3")
+	}
+
+	var dbs []uint8
+	for _, dbFlag := range dbFlags {
+		db, err := strconv.ParseUint(dbFlag, 10, 8)
+		if err != nil {
+			log.Fatalf("Failed parsing parameter flag: invalid DB index %s", dbFlag)
+		}
+		dbs = append(dbs, uint8(db))
+	}
+	if *maxDB < 0 || *maxDB > 255 {
+		log.Fatalf("Failed parsing parameter flag: invalid DB index %d", *maxDB)
+	}
+
+	var redisKeyRegex *regexp.Regexp
+	if *keyRegex != "" {
+		if redisKeyRegex, err = regexp.Compile(*keyRegex); err != nil {
+			log.Fatalf("Failed parsing parameter flag: invalid regular expression: %s", err)
+		}
+	}
+	var redisExcludeRegex *regexp.Regexp
+	if *excludeRegex != "" {
+		if redisExcludeRegex, err = regexp.Compile(*excludeRegex); err != nil {
+			log.Fatalf("Failed parsing parameter flag: invalid regular expression: %s", err)
+		}
+	}
+
+	var redisTLSConfig *tls.Config
+	if *useTLS || *caCert != "" || *cert != "" || *key != "" || *insecure {
+		if redisTLSConfig, err = tlsConfig(*caCert, *cert, *key, *insecure); err != nil {
+			log.Fatalf("Failed loading TLS configuration: %s", err)
+		}
 	}
 
 	var progressNotifs chan redisdump.ProgressNotification
@@ -65,12 +244,166 @@ func realMain() int {
 		}()
 	}
 
-	logger := log.New(os.Stdout, "", 0)
-	if err = redisdump.DumpServer(*host+":"+strconv.Itoa(*port), *nWorkers, logger, serializer, progressNotifs); err != nil {
-		fmt.Println(err)
+	// The password is read from the environment rather than from a flag, so
+	// that it does not show up in the process list
+	redisPassword := os.Getenv("REDISDUMPGO_AUTH")
+
+	network, redisURL := "tcp", *host+":"+strconv.Itoa(*port)
+	if *serverURL != "" {
+		redisURL = *serverURL
+	}
+	if *socket != "" {
+		network, redisURL = "unix", *socket
+	}
+
+	dumpOpts := []redisdump.DumpOption{
+		redisdump.WithNetwork(network),
+		redisdump.WithAuth(*username, redisPassword),
+		redisdump.WithTLSConfig(redisTLSConfig),
+		redisdump.WithDBs(dbs...),
+		redisdump.WithMaxDB(uint8(*maxDB)),
+		redisdump.WithFilter(filters...),
+		redisdump.WithExcludeFilter(excludeFilters...),
+		redisdump.WithKeyRegex(redisKeyRegex),
+		redisdump.WithExcludeRegex(redisExcludeRegex),
+		redisdump.WithTypeFilter(types...),
+		redisdump.WithExcludeType(excludeTypes...),
+		redisdump.WithScanCount(*scanCount),
+		redisdump.WithBatchSize(*batchSize),
+		redisdump.WithPoolSize(*poolSize),
+		redisdump.WithTransactionSize(*transactionSize),
+		redisdump.WithMaxOpsPerSec(*maxOpsPerSec),
+		redisdump.WithRetries(*maxRetries, *retryBackoff),
+		redisdump.WithRateLimit(*rateLimit),
+		redisdump.WithCompression(*compression),
+		redisdump.WithLargeKeyThreshold(*largeKeyThreshold),
+		redisdump.WithLargeKeyBatchSize(*largeKeyBatchSize),
+	}
+	if *pipeline {
+		dumpOpts = append(dumpOpts, redisdump.WithPipeline())
+	}
+	if *restoreHLL {
+		dumpOpts = append(dumpOpts, redisdump.WithHyperLogLogRestore())
+	}
+	if len(sentinels) > 0 {
+		dumpOpts = append(dumpOpts, redisdump.WithSentinel(*masterName, sentinels...))
+	}
+	dumpOpts = append(dumpOpts, redisdump.WithDiagnostics(os.Stderr))
+	if *strict {
+		dumpOpts = append(dumpOpts, redisdump.WithStrict())
+	}
+	if *skipUnsupported {
+		dumpOpts = append(dumpOpts, redisdump.WithSkipUnsupportedTypes())
+	}
+	if *waitReplicas > 0 {
+		dumpOpts = append(dumpOpts, redisdump.WithWaitReplicas(*waitReplicas, *waitTimeout))
+	}
+	if *strictWait {
+		dumpOpts = append(dumpOpts, redisdump.WithStrictWait())
+	}
+	if *continueOnError {
+		dumpOpts = append(dumpOpts, redisdump.WithContinueOnError())
+	}
+	if *dryRun {
+		dumpOpts = append(dumpOpts, redisdump.WithDryRun())
+	}
+	if *verboseStats {
+		dumpOpts = append(dumpOpts, redisdump.WithVerboseStats())
+	}
+	if *topKeys > 0 {
+		dumpOpts = append(dumpOpts, redisdump.WithTopLargestKeys(*topKeys))
+	}
+	if *memoryReport != "" {
+		report, err := os.Create(*memoryReport)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer report.Close()
+		dumpOpts = append(dumpOpts, redisdump.WithMemoryReport(report))
+	}
+	if *dumpRestore {
+		dumpOpts = append(dumpOpts, redisdump.WithDumpRestore())
+	}
+	if *evictionInfo {
+		dumpOpts = append(dumpOpts, redisdump.WithEvictionInfo())
+	}
+	if *flushDB {
+		dumpOpts = append(dumpOpts, redisdump.WithFlushDB())
+	}
+	if *omitSelectForDB0 {
+		dumpOpts = append(dumpOpts, redisdump.WithOmitSelectForDB0())
+	}
+	if *noTTL {
+		dumpOpts = append(dumpOpts, redisdump.WithoutTTL())
+	}
+	if *onlyVolatile && *onlyPersistent {
+		log.Fatalf("Failed parsing parameter flag: -onlyVolatile and -onlyPersistent can not be used together")
+	}
+	if *onlyVolatile {
+		dumpOpts = append(dumpOpts, redisdump.WithOnlyVolatile())
+	}
+	if *onlyPersistent {
+		dumpOpts = append(dumpOpts, redisdump.WithOnlyPersistent())
+	}
+	if *skipExpired {
+		dumpOpts = append(dumpOpts, redisdump.WithSkipExpired())
+	}
+	if *parallelDBs {
+		dumpOpts = append(dumpOpts, redisdump.WithParallelDBs())
+	}
+	if *maxParallelDBs > 0 {
+		dumpOpts = append(dumpOpts, redisdump.WithMaxParallelDBs(*maxParallelDBs))
+	}
+	if *pttl {
+		dumpOpts = append(dumpOpts, redisdump.WithMillisecondTTL())
+	}
+	if *expireTime {
+		dumpOpts = append(dumpOpts, redisdump.WithAbsoluteTTL())
+	}
+	if *encoding {
+		dumpOpts = append(dumpOpts, redisdump.WithObjectEncoding())
+	}
+	if keyDumpSerializer != nil {
+		dumpOpts = append(dumpOpts, redisdump.WithKeyDumpSerializer(keyDumpSerializer))
+	}
+
+	// The first SIGINT or SIGTERM stops the dump cleanly, the next ones are
+	// not caught anymore and kill the process
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			signal.Stop(signals)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var stats redisdump.DumpStats
+	out := bufio.NewWriter(os.Stdout)
+	if *cluster {
+		stats, err = redisdump.DumpCluster(ctx, redisURL, *nWorkers, out, serializer, progressNotifs, dumpOpts...)
+	} else {
+		stats, err = redisdump.DumpServer(ctx, redisURL, *nWorkers, out, serializer, progressNotifs, dumpOpts...)
+	}
+	if flushErr := out.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		return 1
 	}
 
+	if *dryRun {
+		printStats(os.Stdout, stats)
+	} else if *verboseStats || *topKeys > 0 {
+		printStats(os.Stderr, stats)
+	}
+
 	return 0
 }
 
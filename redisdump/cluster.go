@@ -0,0 +1,78 @@
+package redisdump
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// DumpCluster dumps all keys of the Redis Cluster seedURL is a node of, to
+// w. The primaries of the cluster are discovered by radix.Cluster, each is
+// then dumped through its own pool, one after the other, so that every key
+// is read from the primary owning its slot. A Redis Cluster only has DB 0.
+//
+// The dump can not be restored with redis-cli --pipe into a cluster, which
+// does not follow redirections, but can be restored into a standalone server.
+// seedURL may be a redis:// or rediss:// URL, whose DB is ignored. The
+// version of each primary is checked, and WaitReplicas waited for, before it
+// is dumped. DBs, Unix domain sockets, SentinelURLs and PerDBOutput can not be
+// used.
+func DumpCluster(ctx context.Context, seedURL string, nWorkers int, w io.Writer, serializer func([]string) string, progress chan<- ProgressNotification, opts ...DumpOption) (DumpStats, error) {
+	o := newDumpOptions(opts)
+	if err := checkClusterOptions(o); err != nil {
+		return DumpStats{Errors: 1}, err
+	}
+	seedURL, o, err := parseRedisURL(seedURL, o)
+	if err != nil {
+		return DumpStats{Errors: 1}, err
+	}
+
+	poolFunc := func(network, addr string) (radix.Client, error) {
+		return radix.NewPool(network, addr, poolSize(nWorkers, o), radix.PoolConnFunc(connFunc(o)))
+	}
+	cluster, err := radix.NewCluster([]string{seedURL}, radix.ClusterPoolFunc(poolFunc))
+	if err != nil {
+		return DumpStats{Errors: 1}, err
+	}
+	defer cluster.Close()
+
+	return runDump(ctx, w, o.Compression, o, func(w io.Writer, o DumpOptions) error {
+		for _, primary := range cluster.Topo().Primaries() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			client, err := cluster.Client(primary.Addr)
+			if err != nil {
+				return err
+			}
+			if err = checkServer(primary.Addr, o); err != nil {
+				return err
+			}
+			if err = dumpDBWithClient(ctx, client, 0, nWorkers, w, serializer, progress, o); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// checkClusterOptions fails for options that have no meaning in a Redis
+// Cluster, which only has DB 0 and whose nodes are discovered from the seed
+func checkClusterOptions(o DumpOptions) error {
+	var option string
+	switch {
+	case len(o.DBs) > 0:
+		option = "DBs"
+	case o.UnixSocket != "", o.Network == "unix":
+		option = "UnixSocket"
+	case len(o.SentinelURLs) > 0:
+		option = "SentinelURLs"
+	case o.PerDBOutput != nil:
+		option = "PerDBOutput"
+	default:
+		return nil
+	}
+	return fmt.Errorf("Error dumping cluster: %s is not supported with Redis Cluster", option)
+}
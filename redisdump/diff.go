@@ -0,0 +1,209 @@
+package redisdump
+
+import (
+	"crypto/tls"
+	"reflect"
+	"regexp"
+	"sort"
+
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// DiffOptions holds the optional settings of DiffDB, applied to both
+// servers. The zero value compares all keys over a plain TCP connection,
+// without authentication.
+type DiffOptions struct {
+	// Network is either "tcp" or "unix", as in DumpOptions. Defaults to
+	// "tcp".
+	Network string
+
+	// Username and Password are sent with AUTH on every new connection.
+	Username, Password string
+
+	// TLSConfig, if not nil, is used to establish TLS connections.
+	TLSConfig *tls.Config
+
+	// Filters, ExcludeFilters, KeyRegex and ExcludeRegex select the keys
+	// compared, as the keys dumped by DumpDB. Filters defaults to "*".
+	Filters        []string
+	ExcludeFilters []string
+	KeyRegex       *regexp.Regexp
+	ExcludeRegex   *regexp.Regexp
+
+	// ScanCount is the COUNT hint passed to each SCAN call. Defaults to 100.
+	ScanCount int
+
+	// MillisecondTTL compares TTLs read with PTTL, with a tolerance of a
+	// second. AbsoluteTTL compares expiries read with PEXPIRETIME, which
+	// requires Redis 7, and must then be equal.
+	MillisecondTTL bool
+	AbsoluteTTL    bool
+
+	// for tests
+	dial radix.ConnFunc
+}
+
+// dumpOptions returns the DumpOptions reading keys the way d compares them
+func (d DiffOptions) dumpOptions() DumpOptions {
+	return newDumpOptions([]DumpOption{func(o *DumpOptions) {
+		o.Network = d.Network
+		o.Username, o.Password = d.Username, d.Password
+		o.TLSConfig = d.TLSConfig
+		o.Filters = d.Filters
+		o.ExcludeFilters = d.ExcludeFilters
+		o.KeyRegex = d.KeyRegex
+		o.ExcludeRegex = d.ExcludeRegex
+		o.ScanCount = d.ScanCount
+		o.MillisecondTTL = d.MillisecondTTL
+		o.AbsoluteTTL = d.AbsoluteTTL
+		o.dial = d.dial
+	}})
+}
+
+// listKeys returns the keys of the DB client is connected to, matching the
+// filters of o
+func listKeys(client radix.Client, o DumpOptions) (map[string]bool, error) {
+	keys := map[string]bool{}
+	for _, filter := range o.Filters {
+		err := scanKeys(client, filter, o.ScanCount, o.BatchSize, func(keyBatch []string) bool {
+			for _, key := range excludeKeys(keyBatch, o.ExcludeFilters) {
+				if matchKeyRegexes(key, o) {
+					keys[key] = true
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// keyState is what is compared between two instances for a key
+type keyState struct {
+	keyType string
+	value   interface{}
+	ttl     int64
+}
+
+// readKeyState reads the type, value and TTL of key. The members of sets are
+// sorted, since their order is not the same on all instances.
+func readKeyState(client radix.Client, key string, o DumpOptions) (keyState, error) {
+	var ks keyState
+	if err := client.Do(radix.Cmd(&ks.keyType, "TYPE", key)); err != nil {
+		return ks, err
+	}
+	if ks.keyType == "none" {
+		return ks, nil
+	}
+
+	var err error
+	_, ks.value, err = readKey(client, key, ks.keyType, o)
+	if err == errKeyDeleted {
+		ks.keyType = "none"
+		return ks, nil
+	}
+	if err != nil {
+		return ks, err
+	}
+	if members, ok := ks.value.([]string); ok && ks.keyType == "set" {
+		sort.Strings(members)
+	}
+
+	ks.ttl, err = readTTL(client, key, o)
+	return ks, err
+}
+
+// sameTTL reports whether two TTLs are equal, give or take a second to allow
+// for the time elapsed between reading them and for the precision of TTL.
+// Absolute expiries must be equal.
+func sameTTL(a, b int64, o DumpOptions) bool {
+	if a < 0 || b < 0 {
+		return (a < 0) == (b < 0)
+	}
+	if o.AbsoluteTTL {
+		return a == b
+	}
+
+	tolerance := int64(1)
+	if o.MillisecondTTL {
+		tolerance = 1000
+	}
+	d := a - b
+	return -tolerance <= d && d <= tolerance
+}
+
+func diffDB(src, dst radix.Client, o DumpOptions) (added, removed, changed []string, err error) {
+	srcKeys, err := listKeys(src, o)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	dstKeys, err := listKeys(dst, o)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for key := range dstKeys {
+		if !srcKeys[key] {
+			added = append(added, key)
+		}
+	}
+
+	for key := range srcKeys {
+		if !dstKeys[key] {
+			removed = append(removed, key)
+			continue
+		}
+
+		srcState, err := readKeyState(src, key, o)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		dstState, err := readKeyState(dst, key, o)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		// Keys may expire or be deleted while we compare them
+		switch {
+		case srcState.keyType == "none" && dstState.keyType == "none":
+		case srcState.keyType == "none":
+			added = append(added, key)
+		case dstState.keyType == "none":
+			removed = append(removed, key)
+		case srcState.keyType != dstState.keyType,
+			!reflect.DeepEqual(srcState.value, dstState.value),
+			!sameTTL(srcState.ttl, dstState.ttl, o):
+			changed = append(changed, key)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed, nil
+}
+
+// DiffDB compares the DB db of the redis servers given by srcURL and dstURL,
+// such as after a migration. It returns the keys only found on dstURL, the
+// keys only found on srcURL, and the keys found on both but with a different
+// type, value or TTL. TTLs are compared with a tolerance of a second.
+func DiffDB(srcURL, dstURL string, db uint8, opts DiffOptions) (added, removed, changed []string, err error) {
+	o := opts.dumpOptions()
+	dial := withDBSelection(connFunc(o), db)
+
+	src, err := dial(o.Network, srcURL)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer src.Close()
+
+	dst, err := dial(o.Network, dstURL)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer dst.Close()
+
+	return diffDB(src, dst, o)
+}
@@ -0,0 +1,48 @@
+package redisdump
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DumpErrors is returned by dumps made WithContinueOnError when some keys
+// could not be dumped. It holds one error per key that failed.
+type DumpErrors []error
+
+func (e DumpErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d keys could not be dumped: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// errCollector collects the errors of all the workers of a dump
+type errCollector struct {
+	mu   sync.Mutex
+	errs DumpErrors
+}
+
+func (c *errCollector) add(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.errs = append(c.errs, err)
+}
+
+// combine returns err if the dump failed, otherwise the errors collected, if
+// any. A nil collector never collects errors.
+func (c *errCollector) combine(err error) error {
+	if err != nil || c == nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.errs) > 0 {
+		return c.errs
+	}
+	return nil
+}
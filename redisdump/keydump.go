@@ -0,0 +1,150 @@
+package redisdump
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// KeyDump holds a single key as read from Redis, for serializers that need
+// the value of a key rather than the commands to restore it.
+//
+// Value depends on Type: a string for strings, a []string for lists and
+// sets, a map[string]string for hashes, a []ZSetMember for sorted sets and a
+// []StreamEntry for streams. When dumping with WithDumpRestore, it is the
+// []byte payload returned by DUMP, encoded in base64 in JSON. For keys
+// dumped by a TypeHandler, it is the [][]string of commands it returned.
+// TTL is the remaining time to live of the key, in seconds, or in
+// milliseconds when dumping with WithMillisecondTTL; with WithAbsoluteTTL it
+// is the Unix time in milliseconds at which the key expires. It is negative
+// if the key does not expire.
+type KeyDump struct {
+	DB    uint8       `json:"db"`
+	Key   string      `json:"key"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+	TTL   int64       `json:"ttl"`
+
+	// Encoding is the internal encoding of the key, as returned by OBJECT
+	// ENCODING, such as "listpack" or "hashtable". It is only set when
+	// dumping with WithObjectEncoding.
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// ZSetMember is a member of a sorted set. The score is kept as returned by
+// Redis so that it does not lose precision, and since it can be "inf".
+type ZSetMember struct {
+	Member string `json:"member"`
+	Score  string `json:"score"`
+}
+
+// zsetToMembers converts the reply of ZRANGEBYSCORE ... WITHSCORES, a flat
+// list of members and scores, to a list of ZSetMember
+func zsetToMembers(val []string) []ZSetMember {
+	members := make([]ZSetMember, 0, len(val)/2)
+	for i := 0; i+1 < len(val); i += 2 {
+		members = append(members, ZSetMember{Member: val[i], Score: val[i+1]})
+	}
+	return members
+}
+
+// JSONSerializer will serialize k to a JSON object on a single line. Invalid
+// UTF-8 in keys or values is replaced with the Unicode replacement character,
+// use RESPSerializer for binary data.
+func JSONSerializer(k KeyDump) string {
+	// All the types a KeyDump can hold are supported by encoding/json
+	b, _ := json.Marshal(k)
+	return string(b)
+}
+
+// RESP3Serializer will serialize k to a RESP3 map, for RESP3 clients rather
+// than for Redis to restore. Values use the native RESP3 types: hashes are
+// maps, sets are sets, and sorted sets are maps of members to double scores.
+func RESP3Serializer(k KeyDump) string {
+	var b strings.Builder
+	nFields := 5
+	if k.Encoding != "" {
+		nFields++
+	}
+
+	b.WriteString("%" + strconv.Itoa(nFields) + "\r\n")
+	writeRESP3Blob(&b, "db")
+	b.WriteString(":" + strconv.Itoa(int(k.DB)) + "\r\n")
+	writeRESP3Blob(&b, "key")
+	writeRESP3Blob(&b, k.Key)
+	writeRESP3Blob(&b, "type")
+	writeRESP3Blob(&b, k.Type)
+	writeRESP3Blob(&b, "value")
+	writeRESP3Value(&b, k.Type, k.Value)
+	writeRESP3Blob(&b, "ttl")
+	b.WriteString(":" + strconv.FormatInt(k.TTL, 10) + "\r\n")
+	if k.Encoding != "" {
+		writeRESP3Blob(&b, "encoding")
+		writeRESP3Blob(&b, k.Encoding)
+	}
+
+	return b.String()
+}
+
+func writeRESP3Blob(b *strings.Builder, s string) {
+	b.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func writeRESP3Value(b *strings.Builder, keyType string, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		writeRESP3Blob(b, v)
+
+	case []byte:
+		writeRESP3Blob(b, string(v))
+
+	case []string:
+		prefix := "*"
+		if keyType == "set" {
+			prefix = "~"
+		}
+		b.WriteString(prefix + strconv.Itoa(len(v)) + "\r\n")
+		for _, e := range v {
+			writeRESP3Blob(b, e)
+		}
+
+	case map[string]string:
+		b.WriteString("%" + strconv.Itoa(len(v)) + "\r\n")
+		for field, val := range v {
+			writeRESP3Blob(b, field)
+			writeRESP3Blob(b, val)
+		}
+
+	case []ZSetMember:
+		b.WriteString("%" + strconv.Itoa(len(v)) + "\r\n")
+		for _, m := range v {
+			writeRESP3Blob(b, m.Member)
+			b.WriteString("," + m.Score + "\r\n")
+		}
+
+	case []StreamEntry:
+		b.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, e := range v {
+			b.WriteString("*2\r\n")
+			writeRESP3Blob(b, e.ID)
+			b.WriteString("%" + strconv.Itoa(len(e.Fields)/2) + "\r\n")
+			for i := 0; i+1 < len(e.Fields); i += 2 {
+				writeRESP3Blob(b, e.Fields[i])
+				writeRESP3Blob(b, e.Fields[i+1])
+			}
+		}
+
+	case [][]string:
+		b.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, cmd := range v {
+			writeRESP3Value(b, "list", cmd)
+		}
+
+	case nil:
+		b.WriteString("_\r\n")
+
+	default:
+		writeRESP3Blob(b, fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,94 @@
+package redisdump
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	radix "github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// memoryReport collects the memory usage of the keys of a dump, for all its
+// workers. MEMORY USAGE is not sent anymore once the server replied it is
+// unavailable.
+type memoryReport struct {
+	mu          sync.Mutex
+	unavailable bool
+	keys        []KeySizePair
+}
+
+func (r *memoryReport) available() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return !r.unavailable
+}
+
+// setUnavailable records that MEMORY USAGE is not available, and reports
+// whether it was the first time
+func (r *memoryReport) setUnavailable() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	first := !r.unavailable
+	r.unavailable = true
+	return first
+}
+
+func (r *memoryReport) add(key KeySizePair) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.keys = append(r.keys, key)
+}
+
+// writeTo writes the keys collected to w, largest first, one per line
+func (r *memoryReport) writeTo(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sort.SliceStable(r.keys, func(i, j int) bool {
+		return r.keys[i].Bytes > r.keys[j].Bytes
+	})
+	for _, key := range r.keys {
+		if _, err := fmt.Fprintf(w, "%d %s %s %d\n", key.DB, quoteArg(key.Key), key.Type, key.Bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countMemoryUsage counts the memory used by key of the DB db in stats, as
+// estimated by MEMORY USAGE. Keys deleted in the meantime are not counted.
+// Servers without MEMORY USAGE, older than Redis 4, are warned about once,
+// memory usage is then not measured.
+func countMemoryUsage(client radix.Client, db uint8, key, keyType string, o DumpOptions, stats *DumpStats) error {
+	if !o.memory.available() {
+		return nil
+	}
+
+	var size int64
+	mn := radix.MaybeNil{Rcv: &size}
+	if err := client.Do(radix.Cmd(&mn, "MEMORY", "USAGE", key)); err != nil {
+		if _, ok := err.(resp2.Error); !ok {
+			return err
+		}
+		if o.memory.setUnavailable() && o.warnings != nil {
+			return o.warnings.WriteLine(fmt.Sprintf("Warning: MEMORY USAGE is not available, the memory usage of keys is not measured: %s", err))
+		}
+		return nil
+	}
+	if mn.Nil {
+		return nil
+	}
+
+	keySize := KeySizePair{DB: db, Key: key, Type: keyType, Bytes: size}
+	stats.addMemoryUsage(size)
+	stats.addTopKey(keySize, o.TopLargestKeys)
+	if o.MemoryReport != nil {
+		o.memory.add(keySize)
+	}
+	return nil
+}
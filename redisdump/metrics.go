@@ -0,0 +1,109 @@
+package redisdump
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics receives the progress of dumps as they go, for monitoring systems
+// such as Prometheus. Its methods are called by all the workers of a dump,
+// and must be safe for concurrent use.
+type Metrics interface {
+	// KeyDumped is called for each key dumped from the DB db
+	KeyDumped(db uint8)
+
+	// KeyFailed is called for each key of the DB db that could not be dumped
+	KeyFailed(db uint8)
+
+	// BytesWritten is called with the number of bytes of each write to the
+	// output, once compressed
+	BytesWritten(n int)
+
+	// DBDumped is called once the DB db is dumped, or failed to be, with the
+	// time it took
+	DBDumped(db uint8, elapsed time.Duration)
+}
+
+// prometheusMetrics reports dumps to Prometheus, with metrics namespaced with
+// redis_dump. Keys, errors and durations are labelled with their DB.
+type prometheusMetrics struct {
+	keys, errors *prometheus.CounterVec
+	bytes        prometheus.Counter
+	duration     *prometheus.HistogramVec
+}
+
+// newPrometheusMetrics registers the metrics of dumps on reg. Metrics already
+// registered, such as by a previous dump, are reused. Other registration
+// errors, such as metrics of the same name with other labels, are returned.
+func newPrometheusMetrics(reg prometheus.Registerer) (*prometheusMetrics, error) {
+	m := &prometheusMetrics{
+		keys: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "redis_dump",
+			Name:      "keys_total",
+			Help:      "Number of keys dumped.",
+		}, []string{"db"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "redis_dump",
+			Name:      "errors_total",
+			Help:      "Number of keys that could not be dumped.",
+		}, []string{"db"}),
+		bytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "redis_dump",
+			Name:      "bytes_written_total",
+			Help:      "Number of bytes written to the output, once compressed.",
+		}),
+		// Dumps take from seconds to hours
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "redis_dump",
+			Name:      "duration_seconds",
+			Help:      "Time taken to dump each DB.",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+		}, []string{"db"}),
+	}
+
+	collectors := []prometheus.Collector{m.keys, m.errors, m.bytes, m.duration}
+	for i, c := range collectors {
+		var err error
+		if collectors[i], err = register(reg, c); err != nil {
+			return nil, err
+		}
+	}
+	m.keys = collectors[0].(*prometheus.CounterVec)
+	m.errors = collectors[1].(*prometheus.CounterVec)
+	m.bytes = collectors[2].(prometheus.Counter)
+	m.duration = collectors[3].(*prometheus.HistogramVec)
+	return m, nil
+}
+
+// register registers c on reg, and returns the collector registered: c, or
+// the collector of the same type already registered for the same metric
+func register(reg prometheus.Registerer, c prometheus.Collector) (prometheus.Collector, error) {
+	err := reg.Register(c)
+	if are, ok := err.(prometheus.AlreadyRegisteredError); ok && reflect.TypeOf(are.ExistingCollector) == reflect.TypeOf(c) {
+		return are.ExistingCollector, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error registering Prometheus metrics: %s", err)
+	}
+	return c, nil
+}
+
+func (m *prometheusMetrics) KeyDumped(db uint8) {
+	m.keys.WithLabelValues(strconv.Itoa(int(db))).Inc()
+}
+
+func (m *prometheusMetrics) KeyFailed(db uint8) {
+	m.errors.WithLabelValues(strconv.Itoa(int(db))).Inc()
+}
+
+func (m *prometheusMetrics) BytesWritten(n int) {
+	m.bytes.Add(float64(n))
+}
+
+func (m *prometheusMetrics) DBDumped(db uint8, elapsed time.Duration) {
+	m.duration.WithLabelValues(strconv.Itoa(int(db))).Observe(elapsed.Seconds())
+}
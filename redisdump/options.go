@@ -0,0 +1,780 @@
+package redisdump
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"regexp"
+	"time"
+
+	radix "github.com/mediocregopher/radix/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DumpOptions holds the optional settings of a dump. The zero value dumps
+// over a plain TCP connection, without authentication.
+type DumpOptions struct {
+	// Network is either "tcp", in which case the Redis URL is a host:port
+	// address, or "unix" for the Redis URL to be the path to a Unix domain
+	// socket. Defaults to "tcp".
+	Network string
+
+	// UnixSocket, if not empty, is the path of the Unix domain socket of the
+	// server dumped by DumpDB and DumpServer, rather than their Redis URL.
+	// Network is then "unix". It takes precedence over SentinelURLs.
+	UnixSocket string
+
+	// Username and Password are sent with AUTH on every new connection, see
+	// WithAuth.
+	Username, Password string
+
+	// TLSConfig, if not nil, is used to establish TLS connections.
+	TLSConfig *tls.Config
+
+	// SentinelURLs, if not empty, are the addresses of Redis Sentinels
+	// monitoring MasterName. The Redis URL given to DumpDB or DumpServer is
+	// then ignored, the current master is asked to the Sentinels when the
+	// dump starts, and dumped. With MaxRetries, the master is asked again
+	// after connection errors, and the dump resumes from the new master if it
+	// failed over: the retried SCAN carries on from its cursor, keys already
+	// dumped are not dumped again. As the hash seed differs between servers,
+	// keys may then be missed or dumped twice, and writes not replicated to
+	// the new master are not dumped. Without MaxRetries, the dump fails on a
+	// failover. Sentinels are connected to with the TLSConfig, Username and
+	// Password of the server.
+	SentinelURLs []string
+	MasterName   string
+
+	// DBs are the indexes of the DBs dumped by DumpServer. When empty, all DBs
+	// holding keys are dumped.
+	DBs []uint8
+
+	// MaxDB, if not 0, is the index of the last DB of the server, for
+	// servers where the number of databases can not be read with CONFIG
+	// GET databases, such as managed services disabling CONFIG. DBs listed
+	// by INFO keyspace beyond it are an error. Servers with more than 256
+	// DBs are not supported.
+	MaxDB uint8
+
+	// FlushDB writes a FLUSHDB right after the SELECT of each DB, so that
+	// restoring the dump first deletes all keys of the DB. It is ignored
+	// with KeyDumpSerializer.
+	FlushDB bool
+
+	// OmitSelectForDB0 leaves out the SELECT 0 written before the keys of
+	// DB 0, as clients start on DB 0, when DB 0 is the first DB of its
+	// output. It is still written when DumpServer dumps another DB before it
+	// to the same output.
+	OmitSelectForDB0 bool
+
+	// ParallelDBs makes DumpServer dump all DBs at the same time, each with
+	// its own connections. The output of each DB is buffered in a temporary
+	// file until it can be written after the previous DBs.
+	ParallelDBs bool
+
+	// MaxParallelDBs, if positive, is the maximum number of DBs dumped at the
+	// same time with ParallelDBs. 0 means all of them.
+	MaxParallelDBs int
+
+	// Filters are glob-style patterns, only keys matching any of them are
+	// dumped. Each filter is passed as MATCH to its own SCAN pass over the
+	// keys, so that keys are filtered by the server. Defaults to "*".
+	Filters []string
+
+	// ExcludeFilters are glob-style patterns, keys matching any of them are
+	// not dumped, even if they match one of Filters. Unlike Filters, they are
+	// applied by the client.
+	ExcludeFilters []string
+
+	// KeyRegex, if not nil, restricts the dump to keys it matches, for
+	// filters that can not be expressed as glob-style patterns. Unlike
+	// Filters, it is applied by the client.
+	KeyRegex *regexp.Regexp
+
+	// ExcludeRegex, if not nil, skips the keys it matches, such as
+	// framework internal keys, even if they match Filters or KeyRegex. Like
+	// ExcludeFilters, it is applied by the client.
+	ExcludeRegex *regexp.Regexp
+
+	// TypeFilter, if not empty, restricts the dump to keys of these types, as
+	// returned by TYPE, such as "hash" or "zset".
+	TypeFilter []string
+
+	// ExcludeTypes are types of keys not dumped, as returned by TYPE, such
+	// as "stream", even if they are in TypeFilter.
+	ExcludeTypes []string
+
+	// ScanCount is the COUNT hint passed to each SCAN call. Defaults to 100.
+	ScanCount int
+
+	// BatchSize is the number of keys handed to a worker at once. Larger
+	// batches reduce the overhead of dispatching keys, smaller ones make
+	// progress notifications more frequent. Batches waiting to be dumped use
+	// memory in proportion to the size of key names. Defaults to 100.
+	BatchSize int
+
+	// Pipeline reads the keys of a batch in about three round trips, rather
+	// than three or more per key: their types, the number of elements of
+	// lists, sets, hashes and sorted sets, then their values, each followed
+	// by its TTL. Keys above LargeKeyThreshold, streams, and keys dumped
+	// with UseDumpRestore, HyperLogLogRestore or a TypeHandler are still read
+	// one command at a time. The values of a batch are held in memory until
+	// they are written.
+	Pipeline bool
+
+	// TransactionSize, if positive, wraps the commands restoring each batch
+	// of keys in MULTI and EXEC, so that they are restored atomically, in
+	// transactions of at most TransactionSize commands. Transactions hold
+	// whole keys: a key restored with more commands gets a transaction of
+	// its own. A transaction is only written once complete, so a dump
+	// interrupted never ends with part of one. It has no effect with
+	// KeyDumpSerializer.
+	TransactionSize int
+
+	// PoolSize is the number of connections to the server shared by the
+	// nWorkers workers of each DB. Workers blocked on I/O may leave
+	// connections idle, so fewer connections than workers may be enough.
+	// Defaults to the number of workers, and at least 5.
+	PoolSize int
+
+	// LargeKeyThreshold is the number of elements above which hashes, sets
+	// and sorted sets are read incrementally with HSCAN, SSCAN and ZSCAN,
+	// rather than with HGETALL, SMEMBERS and ZRANGEBYSCORE, and lists with
+	// several LRANGE of LargeKeyBatchSize elements. Defaults to 512, a
+	// negative threshold disables incremental reads.
+	LargeKeyThreshold int
+
+	// LargeKeyBatchSize is the maximum number of elements restored by each
+	// command for keys above LargeKeyThreshold, so that they are restored
+	// with several HSET, SADD, ZADD or RPUSH. Defaults to 1000.
+	LargeKeyBatchSize int
+
+	// RateLimit, if positive, is the maximum number of keys dumped per
+	// second by each DumpDB, all workers included. 0 means unlimited.
+	RateLimit float64
+
+	// MaxOpsPerSec, if positive, is the maximum number of commands sent to
+	// Redis per second by each DumpDB, all workers included, so that the dump
+	// does not starve other clients. It may be below 1, such as 0.5 for a
+	// command every 2 seconds. 0 means unlimited.
+	MaxOpsPerSec float64
+
+	// MaxRetries is the number of times commands and connections failing
+	// with a connection error, such as when the server restarts, are retried
+	// before the dump fails. The first retry waits RetryBackoff, 100ms by
+	// default, and each of the next ones twice as long as the previous one.
+	// Errors replied by the server are not retried.
+	MaxRetries   int
+	RetryBackoff time.Duration
+
+	// NoTTL skips reading the TTL of keys, the dump then contains no
+	// expiry and restored keys never expire.
+	NoTTL bool
+
+	// MillisecondTTL reads TTLs with PTTL and restores them with PEXPIREAT,
+	// rather than TTL and EXPIREAT which only have a precision of a second.
+	MillisecondTTL bool
+
+	// AbsoluteTTL reads the expiry of keys with PEXPIRETIME, which requires
+	// Redis 7, and restores it with PEXPIREAT as is. Keys then expire at the
+	// same time once restored, however long the dump and restore take.
+	AbsoluteTTL bool
+
+	// OnlyVolatile only dumps keys with an expiry, and OnlyPersistent only
+	// keys without, such as to migrate cached data apart from durable data.
+	// The TTL of keys is then read before their value, even with NoTTL.
+	OnlyVolatile   bool
+	OnlyPersistent bool
+
+	// SkipExpired skips keys that expire, or are deleted, between the
+	// reading of their value and of their TTL, rather than dumping them
+	// without expiry. It has no effect with NoTTL.
+	SkipExpired bool
+
+	// HyperLogLogRestore dumps HyperLogLogs with DUMP and restores them with
+	// RESTORE, rather than with a SET of their binary representation.
+	HyperLogLogRestore bool
+
+	// UseDumpRestore dumps every key with DUMP and restores it with RESTORE,
+	// rather than with commands specific to its type. The exact encoding of
+	// keys is kept, and keys of any type, including module types, can be
+	// dumped. The restoring server must be of a compatible version.
+	UseDumpRestore bool
+
+	// EvictionInfo restores keys dumped with UseDumpRestore with their LRU
+	// idle time or LFU access frequency, depending on the maxmemory-policy
+	// of the server, read with OBJECT IDLETIME or OBJECT FREQ. This costs
+	// one or two more commands per key.
+	EvictionInfo bool
+
+	// ObjectEncoding reads the internal encoding of keys with OBJECT
+	// ENCODING, for the Encoding of KeyDump. It has no effect on the
+	// commands restoring keys.
+	ObjectEncoding bool
+
+	// SkipUnsupportedTypes skips keys of a type that can not be dumped, such
+	// as the types of modules without a TypeHandler, with a warning, rather
+	// than failing the dump. They are counted in DumpStats.UnsupportedKeys.
+	// UseDumpRestore dumps keys of any type.
+	SkipUnsupportedTypes bool
+
+	// TypeHandlers dump the keys of the types they handle, rather than the
+	// package. The first handler able to dump a type is used.
+	TypeHandlers []TypeHandler
+
+	// DryRun only counts keys in DumpStats, by type, along with their memory
+	// usage, as given by MEMORY USAGE which requires Redis 4. The values of
+	// keys are not read, and nothing is written to the output.
+	DryRun bool
+
+	// VerboseStats measures the memory usage of keys in DumpStats during a
+	// dump as well, with one more MEMORY USAGE command per key.
+	VerboseStats bool
+
+	// TopLargestKeys is the number of keys reported in DumpStats.TopKeys,
+	// the largest ones by memory usage. Memory usage is then measured
+	// during dumps too, as with VerboseStats.
+	TopLargestKeys int
+
+	// MemoryReport, if not nil, receives the memory usage of every key
+	// dumped once the dump is done, largest first, as lines of the DB, the
+	// key quoted as by RedisCmdSerializer, its type and its size in bytes.
+	// It is kept apart from the dump, which can still be restored. All
+	// sizes are kept in memory until the dump is done.
+	MemoryReport io.Writer
+
+	// Compression, if not empty, compresses the dump written to w. Only
+	// "gzip" is supported.
+	Compression string
+
+	// PerDBOutput, if not nil, gives the writer each DB is dumped to by
+	// DumpServer, rather than w, such as a file per DB. Writers that are an
+	// io.Closer are closed once their DB is dumped. With Compression, the
+	// output of each DB is compressed on its own.
+	PerDBOutput func(db uint8) io.Writer
+
+	// Output, if not nil, starts the upload the dump is written to by
+	// DumpDB, DumpServer and DumpCluster, rather than w, such as S3Output.
+	// The upload is completed once the dump is complete, and aborted if the
+	// dump or its completion fails, as with DumpToUpload.
+	Output func(ctx context.Context) (Upload, error)
+
+	// ContinueOnError carries on with the next key when a key can not be
+	// dumped, rather than stopping the dump. The dump then returns a
+	// DumpErrors listing the keys that failed.
+	ContinueOnError bool
+
+	// Diagnostics, if not nil, receives warnings about the dump, one per
+	// line, such as keys deleted between SCAN and the reading of their
+	// value.
+	Diagnostics io.Writer
+
+	// Strict fails the dump of keys deleted between SCAN and the reading of
+	// their value, rather than skipping them.
+	Strict bool
+
+	// WaitReplicas, if positive, is the number of replicas DumpDB and
+	// DumpServer wait for with WAIT before dumping, for WaitTimeout, 0
+	// meaning forever. WAIT only waits for the writes made on its own
+	// connection: as the dump makes none, it returns once WaitReplicas
+	// replicas are connected and acknowledging, whether or not they caught
+	// up with the writes of other clients.
+	WaitReplicas int
+	WaitTimeout  time.Duration
+
+	// StrictWait fails the dump when fewer than WaitReplicas replicas
+	// acknowledge within WaitTimeout, rather than warning about it.
+	StrictWait bool
+
+	// Tracer, if not nil, starts a span named redisdump.DumpDB for each DB
+	// dumped, as a child of the span of the context of the dump, and a span
+	// named redisdump.batch for each of its batches of keys. Their attributes
+	// are db, batch_size and key_count.
+	Tracer trace.Tracer
+
+	// Metrics, if not nil, receives the keys dumped, the keys that failed,
+	// the bytes written and the time each DB took, as the dump goes. See
+	// WithPrometheusRegistry to report them to Prometheus.
+	Metrics Metrics
+
+	// KeyDumpSerializer, if not nil, is used to serialize each key instead of
+	// the commands to restore it. No SELECT is then written for each DB, the
+	// DB of every key is part of its KeyDump.
+	KeyDumpSerializer func(KeyDump) string
+
+	// errs collects the errors of keys with ContinueOnError
+	errs *errCollector
+
+	// warnings writes to Diagnostics, for all the workers of a dump
+	warnings *lineWriter
+
+	// memory collects the memory usage of keys for MemoryReport
+	memory *memoryReport
+
+	// stats, if not nil, is filled with statistics about the dump. The
+	// statistics of all the DBs dumped by DumpServer are added up.
+	stats *DumpStats
+
+	// registry, if not nil, is the Prometheus registry the metrics of the
+	// dump are registered on when it starts, see WithPrometheusRegistry
+	registry prometheus.Registerer
+
+	// dial, if not nil, replaces the dialer establishing connections, for
+	// tests
+	dial radix.ConnFunc
+}
+
+// DumpOption sets one of the DumpOptions
+type DumpOption func(*DumpOptions)
+
+// WithNetwork sets the network used to connect to Redis, "tcp" or "unix"
+func WithNetwork(network string) DumpOption {
+	return func(o *DumpOptions) {
+		o.Network = network
+	}
+}
+
+// WithUnixSocket connects to the server through the Unix domain socket at
+// path, such as /var/run/redis/redis.sock
+func WithUnixSocket(path string) DumpOption {
+	return func(o *DumpOptions) {
+		o.UnixSocket = path
+	}
+}
+
+// WithAuth authenticates every connection with AUTH. When username is not
+// empty, the Redis 6 ACL form AUTH <username> <password> is used, otherwise
+// the legacy AUTH <password>.
+func WithAuth(username, password string) DumpOption {
+	return func(o *DumpOptions) {
+		o.Username = username
+		o.Password = password
+	}
+}
+
+// WithTLSConfig connects to Redis using TLS, configured by tlsConfig
+func WithTLSConfig(tlsConfig *tls.Config) DumpOption {
+	return func(o *DumpOptions) {
+		o.TLSConfig = tlsConfig
+	}
+}
+
+// WithSentinel dumps the master of masterName, as known by the Sentinels at
+// sentinelURLs when the dump starts
+func WithSentinel(masterName string, sentinelURLs ...string) DumpOption {
+	return func(o *DumpOptions) {
+		o.MasterName = masterName
+		o.SentinelURLs = append(o.SentinelURLs, sentinelURLs...)
+	}
+}
+
+// WithDBs restricts DumpServer to the DBs of indexes dbs
+func WithDBs(dbs ...uint8) DumpOption {
+	return func(o *DumpOptions) {
+		o.DBs = append(o.DBs, dbs...)
+	}
+}
+
+// WithMaxDB sets the index of the last DB of the server, see MaxDB
+func WithMaxDB(db uint8) DumpOption {
+	return func(o *DumpOptions) {
+		o.MaxDB = db
+	}
+}
+
+// WithFlushDB empties each DB when restoring the dump, before restoring its
+// keys
+func WithFlushDB() DumpOption {
+	return func(o *DumpOptions) {
+		o.FlushDB = true
+	}
+}
+
+// WithOmitSelectForDB0 leaves out the SELECT before the keys of DB 0, see
+// OmitSelectForDB0
+func WithOmitSelectForDB0() DumpOption {
+	return func(o *DumpOptions) {
+		o.OmitSelectForDB0 = true
+	}
+}
+
+// WithParallelDBs dumps all DBs at the same time
+func WithParallelDBs() DumpOption {
+	return func(o *DumpOptions) {
+		o.ParallelDBs = true
+	}
+}
+
+// WithMaxParallelDBs dumps up to n DBs at the same time, see MaxParallelDBs
+func WithMaxParallelDBs(n int) DumpOption {
+	return func(o *DumpOptions) {
+		o.ParallelDBs = true
+		o.MaxParallelDBs = n
+	}
+}
+
+// WithFilter only dumps keys matching any of the glob-style patterns
+// filters, such as "session:*"
+func WithFilter(filters ...string) DumpOption {
+	return func(o *DumpOptions) {
+		o.Filters = append(o.Filters, filters...)
+	}
+}
+
+// WithExcludeFilter does not dump keys matching any of the glob-style
+// patterns filters. Exclusions take precedence over WithFilter.
+func WithExcludeFilter(filters ...string) DumpOption {
+	return func(o *DumpOptions) {
+		o.ExcludeFilters = append(o.ExcludeFilters, filters...)
+	}
+}
+
+// WithKeyRegex only dumps keys matching keyRegex
+func WithKeyRegex(keyRegex *regexp.Regexp) DumpOption {
+	return func(o *DumpOptions) {
+		o.KeyRegex = keyRegex
+	}
+}
+
+// WithExcludeRegex does not dump keys matching excludeRegex
+func WithExcludeRegex(excludeRegex *regexp.Regexp) DumpOption {
+	return func(o *DumpOptions) {
+		o.ExcludeRegex = excludeRegex
+	}
+}
+
+// WithTypeFilter only dumps keys of the given types, such as "hash"
+func WithTypeFilter(types ...string) DumpOption {
+	return func(o *DumpOptions) {
+		o.TypeFilter = append(o.TypeFilter, types...)
+	}
+}
+
+// WithExcludeType does not dump keys of the given types, such as "stream"
+func WithExcludeType(types ...string) DumpOption {
+	return func(o *DumpOptions) {
+		o.ExcludeTypes = append(o.ExcludeTypes, types...)
+	}
+}
+
+// WithScanCount sets the COUNT hint passed to each SCAN call
+func WithScanCount(scanCount int) DumpOption {
+	return func(o *DumpOptions) {
+		o.ScanCount = scanCount
+	}
+}
+
+// WithBatchSize sets the number of keys handed to a worker at once
+func WithBatchSize(batchSize int) DumpOption {
+	return func(o *DumpOptions) {
+		o.BatchSize = batchSize
+	}
+}
+
+// WithPipeline reads the keys of each batch in a few round trips, see
+// Pipeline
+func WithPipeline() DumpOption {
+	return func(o *DumpOptions) {
+		o.Pipeline = true
+	}
+}
+
+// WithTransactionSize wraps the commands of each batch of keys in MULTI and
+// EXEC, in transactions of at most size commands, see TransactionSize
+func WithTransactionSize(size int) DumpOption {
+	return func(o *DumpOptions) {
+		o.TransactionSize = size
+	}
+}
+
+// WithPoolSize sets the number of connections of each DB, see PoolSize
+func WithPoolSize(poolSize int) DumpOption {
+	return func(o *DumpOptions) {
+		o.PoolSize = poolSize
+	}
+}
+
+// WithLargeKeyThreshold reads keys with more than threshold elements
+// incrementally, so that the server is not blocked while reading them
+func WithLargeKeyThreshold(threshold int) DumpOption {
+	return func(o *DumpOptions) {
+		o.LargeKeyThreshold = threshold
+	}
+}
+
+// WithLargeKeyBatchSize sets the maximum number of elements restored by each
+// command for keys above the large key threshold
+func WithLargeKeyBatchSize(batchSize int) DumpOption {
+	return func(o *DumpOptions) {
+		o.LargeKeyBatchSize = batchSize
+	}
+}
+
+// WithRateLimit limits the number of keys dumped per second
+func WithRateLimit(keysPerSecond float64) DumpOption {
+	return func(o *DumpOptions) {
+		o.RateLimit = keysPerSecond
+	}
+}
+
+// WithMaxOpsPerSec limits the number of commands sent to Redis per second
+func WithMaxOpsPerSec(maxOpsPerSec float64) DumpOption {
+	return func(o *DumpOptions) {
+		o.MaxOpsPerSec = maxOpsPerSec
+	}
+}
+
+// WithRetries retries commands failing with a connection error up to
+// maxRetries times, with an exponential backoff starting at backoff, see
+// MaxRetries
+func WithRetries(maxRetries int, backoff time.Duration) DumpOption {
+	return func(o *DumpOptions) {
+		o.MaxRetries = maxRetries
+		o.RetryBackoff = backoff
+	}
+}
+
+// WithoutTTL does not dump the TTL of keys
+func WithoutTTL() DumpOption {
+	return func(o *DumpOptions) {
+		o.NoTTL = true
+	}
+}
+
+// WithAbsoluteTTL dumps the absolute expiry of keys, see AbsoluteTTL
+func WithAbsoluteTTL() DumpOption {
+	return func(o *DumpOptions) {
+		o.AbsoluteTTL = true
+	}
+}
+
+// WithSkipExpired skips keys expiring while they are dumped, see SkipExpired
+func WithSkipExpired() DumpOption {
+	return func(o *DumpOptions) {
+		o.SkipExpired = true
+	}
+}
+
+// WithOnlyVolatile only dumps keys with an expiry
+func WithOnlyVolatile() DumpOption {
+	return func(o *DumpOptions) {
+		o.OnlyVolatile = true
+	}
+}
+
+// WithOnlyPersistent only dumps keys without expiry
+func WithOnlyPersistent() DumpOption {
+	return func(o *DumpOptions) {
+		o.OnlyPersistent = true
+	}
+}
+
+// WithMillisecondTTL dumps TTLs with a millisecond precision
+func WithMillisecondTTL() DumpOption {
+	return func(o *DumpOptions) {
+		o.MillisecondTTL = true
+	}
+}
+
+// WithHyperLogLogRestore dumps HyperLogLogs with DUMP and RESTORE
+func WithHyperLogLogRestore() DumpOption {
+	return func(o *DumpOptions) {
+		o.HyperLogLogRestore = true
+	}
+}
+
+// WithDumpRestore dumps all keys with DUMP and RESTORE
+func WithDumpRestore() DumpOption {
+	return func(o *DumpOptions) {
+		o.UseDumpRestore = true
+	}
+}
+
+// WithEvictionInfo restores the LRU or LFU information of keys dumped with
+// DUMP, see EvictionInfo
+func WithEvictionInfo() DumpOption {
+	return func(o *DumpOptions) {
+		o.EvictionInfo = true
+	}
+}
+
+// WithObjectEncoding adds the internal encoding of keys to KeyDump
+func WithObjectEncoding() DumpOption {
+	return func(o *DumpOptions) {
+		o.ObjectEncoding = true
+	}
+}
+
+// WithSkipUnsupportedTypes skips keys of types that can not be dumped, see
+// SkipUnsupportedTypes
+func WithSkipUnsupportedTypes() DumpOption {
+	return func(o *DumpOptions) {
+		o.SkipUnsupportedTypes = true
+	}
+}
+
+// WithTypeHandler registers handlers dumping keys of custom types, such as
+// the types of Redis modules
+func WithTypeHandler(handlers ...TypeHandler) DumpOption {
+	return func(o *DumpOptions) {
+		o.TypeHandlers = append(o.TypeHandlers, handlers...)
+	}
+}
+
+// WithDryRun only counts the keys that would be dumped, see DryRun
+func WithDryRun() DumpOption {
+	return func(o *DumpOptions) {
+		o.DryRun = true
+	}
+}
+
+// WithVerboseStats measures the memory usage of the keys dumped
+func WithVerboseStats() DumpOption {
+	return func(o *DumpOptions) {
+		o.VerboseStats = true
+	}
+}
+
+// WithMemoryReport writes the memory usage of every key dumped to w, see
+// MemoryReport
+func WithMemoryReport(w io.Writer) DumpOption {
+	return func(o *DumpOptions) {
+		o.MemoryReport = w
+	}
+}
+
+// WithTopLargestKeys reports the n largest keys dumped in DumpStats.TopKeys
+func WithTopLargestKeys(n int) DumpOption {
+	return func(o *DumpOptions) {
+		o.TopLargestKeys = n
+	}
+}
+
+// WithCompression compresses the dump with compression, such as "gzip"
+func WithCompression(compression string) DumpOption {
+	return func(o *DumpOptions) {
+		o.Compression = compression
+	}
+}
+
+// WithPerDBOutput dumps each DB to the writer returned by output, see
+// PerDBOutput
+func WithPerDBOutput(output func(db uint8) io.Writer) DumpOption {
+	return func(o *DumpOptions) {
+		o.PerDBOutput = output
+	}
+}
+
+// WithContinueOnError carries on dumping when a key can not be dumped
+func WithContinueOnError() DumpOption {
+	return func(o *DumpOptions) {
+		o.ContinueOnError = true
+	}
+}
+
+// WithDiagnostics writes warnings about the dump to w
+func WithDiagnostics(w io.Writer) DumpOption {
+	return func(o *DumpOptions) {
+		o.Diagnostics = w
+	}
+}
+
+// WithStrict fails the dump of keys deleted while they are dumped
+func WithStrict() DumpOption {
+	return func(o *DumpOptions) {
+		o.Strict = true
+	}
+}
+
+// WithWaitReplicas waits for n replicas with WAIT before dumping, for at
+// most timeout, see WaitReplicas
+func WithWaitReplicas(n int, timeout time.Duration) DumpOption {
+	return func(o *DumpOptions) {
+		o.WaitReplicas = n
+		o.WaitTimeout = timeout
+	}
+}
+
+// WithStrictWait fails the dump when fewer replicas than WaitReplicas
+// acknowledge in time
+func WithStrictWait() DumpOption {
+	return func(o *DumpOptions) {
+		o.StrictWait = true
+	}
+}
+
+// WithTracer traces dumps with the OpenTelemetry tracer, see Tracer
+func WithTracer(tracer trace.Tracer) DumpOption {
+	return func(o *DumpOptions) {
+		o.Tracer = tracer
+	}
+}
+
+// WithMetrics reports the progress of dumps to metrics, see Metrics
+func WithMetrics(metrics Metrics) DumpOption {
+	return func(o *DumpOptions) {
+		o.Metrics = metrics
+	}
+}
+
+// WithPrometheusRegistry reports the progress of dumps to Prometheus, with
+// metrics registered on reg: redis_dump_keys_total, redis_dump_errors_total,
+// redis_dump_bytes_written_total and redis_dump_duration_seconds, a histogram
+// of the time taken by each DB. Keys, errors and durations have a db label.
+// Metrics are registered when the dump starts, which fails if they conflict
+// with metrics already registered on reg. They replace Metrics.
+func WithPrometheusRegistry(reg prometheus.Registerer) DumpOption {
+	return func(o *DumpOptions) {
+		o.registry = reg
+	}
+}
+
+// WithKeyDumpSerializer serializes keys with serializer, such as
+// JSONSerializer, rather than the serializer passed to DumpDB
+func WithKeyDumpSerializer(serializer func(KeyDump) string) DumpOption {
+	return func(o *DumpOptions) {
+		o.KeyDumpSerializer = serializer
+	}
+}
+
+func newDumpOptions(opts []DumpOption) DumpOptions {
+	var o DumpOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.ContinueOnError {
+		o.errs = &errCollector{}
+	}
+	if o.Diagnostics != nil {
+		o.warnings = newLineWriter(o.Diagnostics)
+	}
+	o.memory = &memoryReport{}
+	if o.UnixSocket != "" {
+		o.Network = "unix"
+	}
+	if o.Network == "" {
+		o.Network = "tcp"
+	}
+	if len(o.Filters) == 0 {
+		o.Filters = []string{"*"}
+	}
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = 100 * time.Millisecond
+	}
+	if o.ScanCount < 1 {
+		o.ScanCount = 100
+	}
+	if o.BatchSize < 1 {
+		o.BatchSize = 100
+	}
+	if o.LargeKeyThreshold == 0 {
+		o.LargeKeyThreshold = 512
+	}
+	if o.LargeKeyBatchSize < 1 {
+		o.LargeKeyBatchSize = 1000
+	}
+
+	return o
+}
@@ -0,0 +1,222 @@
+package redisdump
+
+import (
+	"bufio"
+
+	radix "github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// pipeline is a radix.Action sending all its commands before reading their
+// replies, so that they take a single round trip. Unlike radix.Pipeline, the
+// replies following an error replied by the server are still read, so that
+// the connection can be reused, and commands are encoded one at a time, as
+// radix.Stub requires.
+type pipeline []radix.CmdAction
+
+func (p pipeline) Keys() []string {
+	var keys []string
+	for _, cmd := range p {
+		keys = append(keys, cmd.Keys()...)
+	}
+	return keys
+}
+
+func (p pipeline) Run(conn radix.Conn) error {
+	for _, cmd := range p {
+		if err := conn.Encode(cmd); err != nil {
+			return err
+		}
+	}
+
+	var replyErr error
+	for _, cmd := range p {
+		err := conn.Decode(cmd)
+		if _, ok := err.(resp2.Error); err != nil && !ok {
+			return err
+		}
+		if replyErr == nil {
+			replyErr = err
+		}
+	}
+	return replyErr
+}
+
+// keptErrorCmd is a radix.CmdAction keeping the error replied by the server
+// to its command rather than returning it, so that one key failing in a
+// pipeline does not fail the others
+type keptErrorCmd struct {
+	radix.CmdAction
+	err error
+}
+
+func (c *keptErrorCmd) UnmarshalRESP(br *bufio.Reader) error {
+	err := c.CmdAction.UnmarshalRESP(br)
+	if _, ok := err.(resp2.Error); ok {
+		c.err = err
+		return nil
+	}
+	return err
+}
+
+// keyInfo is the type, TTL and value of a key, read ahead of dumping it. The
+// value is read along with the commands restoring it, or the error reading
+// it or its TTL failed with.
+type keyInfo struct {
+	keyType string
+	ttl     int64
+	ttlRead bool
+
+	redisCmds [][]string
+	value     interface{}
+	err       error
+	valueRead bool
+}
+
+// lenCmds are the commands returning the number of elements of keys, by type
+var lenCmds = map[string]string{
+	"list": "LLEN",
+	"set":  "SCARD",
+	"hash": "HLEN",
+	"zset": "ZCARD",
+}
+
+// readKeyInfos reads the keys to dump in three round trips: their types, the
+// number of elements of lists, sets, hashes and sorted sets, then their
+// values, each followed by its TTL as with readKey and readTTL. With
+// OnlyVolatile, OnlyPersistent or DryRun, TTLs are read with the types
+// instead, as dumpKeys does, and no value is read with DryRun. Keys above
+// LargeKeyThreshold, streams, and keys read with DUMP or a TypeHandler are
+// left to readKey.
+func readKeyInfos(client radix.Client, keys []string, o DumpOptions) (map[string]keyInfo, error) {
+	var included []string
+	for _, key := range excludeKeys(keys, o.ExcludeFilters) {
+		if matchKeyRegexes(key, o) {
+			included = append(included, key)
+		}
+	}
+	if len(included) == 0 {
+		return nil, nil
+	}
+
+	ttlFirst := o.OnlyVolatile || o.OnlyPersistent || (o.DryRun && !o.NoTTL)
+	keyTypes := make([]string, len(included))
+	ttls := make([]int64, len(included))
+	var p pipeline
+	for i, key := range included {
+		p = append(p, radix.Cmd(&keyTypes[i], "TYPE", key))
+		if ttlFirst {
+			p = append(p, radix.Cmd(&ttls[i], ttlCmd(o), key))
+		}
+	}
+	if err := client.Do(p); err != nil {
+		return nil, err
+	}
+
+	infos := make(map[string]keyInfo, len(included))
+	var toRead []string
+	for i, key := range included {
+		infos[key] = keyInfo{keyType: keyTypes[i], ttl: ttls[i], ttlRead: ttlFirst}
+		if prefetchValue(keyTypes[i], ttls[i], ttlFirst, o) {
+			toRead = append(toRead, key)
+		}
+	}
+
+	if o.LargeKeyThreshold >= 0 {
+		var err error
+		if toRead, err = excludeLargeKeys(client, toRead, infos, o); err != nil {
+			return nil, err
+		}
+	}
+	if len(toRead) == 0 {
+		return infos, nil
+	}
+
+	// Values are read with their TTL right after, as by readKey and readTTL
+	parses := make([]func() ([][]string, interface{}, error), len(toRead))
+	valueCmds := make([]*keptErrorCmd, len(toRead))
+	ttlCmds := make([]*keptErrorCmd, len(toRead))
+	p = nil
+	for i, key := range toRead {
+		var cmd radix.CmdAction
+		cmd, parses[i] = valueCmd(key, infos[key].keyType)
+		valueCmds[i] = &keptErrorCmd{CmdAction: cmd}
+		p = append(p, valueCmds[i])
+		if !o.NoTTL && !ttlFirst {
+			ttlCmds[i] = &keptErrorCmd{CmdAction: radix.Cmd(&ttls[i], ttlCmd(o), key)}
+			p = append(p, ttlCmds[i])
+		}
+	}
+	if err := client.Do(p); err != nil {
+		return nil, err
+	}
+
+	for i, key := range toRead {
+		info := infos[key]
+		info.valueRead = true
+		if info.err = valueCmds[i].err; info.err == nil {
+			info.redisCmds, info.value, info.err = parses[i]()
+		}
+		if ttlCmds[i] != nil {
+			info.ttl, info.ttlRead = ttls[i], true
+			if info.err == nil {
+				info.err = ttlCmds[i].err
+			}
+		}
+		infos[key] = info
+	}
+	return infos, nil
+}
+
+// prefetchValue reports whether the value of a key of keyType, and TTL ttl if
+// ttlRead, is read by readKeyInfos: it must be dumped, and be of a type read
+// with a single command
+func prefetchValue(keyType string, ttl int64, ttlRead bool, o DumpOptions) bool {
+	switch {
+	case o.DryRun, o.UseDumpRestore:
+		return false
+	case typeHandler(o.TypeHandlers, keyType) != nil:
+		return false
+	case keyType == "string" && o.HyperLogLogRestore:
+		return false
+	case len(o.TypeFilter) > 0 && !containsString(o.TypeFilter, keyType), containsString(o.ExcludeTypes, keyType):
+		return false
+	case ttlRead && (ttl == -2 || !matchTTLFilter(ttl, o)):
+		return false
+	}
+	cmd, _ := valueCmd("", keyType)
+	return cmd != nil
+}
+
+// excludeLargeKeys returns the keys of keys with at most LargeKeyThreshold
+// elements, reading the number of elements of those that are not strings in
+// a single round trip
+func excludeLargeKeys(client radix.Client, keys []string, infos map[string]keyInfo, o DumpOptions) ([]string, error) {
+	lens := make([]int, len(keys))
+	lenCmdsRun := make([]*keptErrorCmd, len(keys))
+	var p pipeline
+	for i, key := range keys {
+		if lenCmd, ok := lenCmds[infos[key].keyType]; ok {
+			lenCmdsRun[i] = &keptErrorCmd{CmdAction: radix.Cmd(&lens[i], lenCmd, key)}
+			p = append(p, lenCmdsRun[i])
+		}
+	}
+	if len(p) == 0 {
+		return keys, nil
+	}
+	if err := client.Do(p); err != nil {
+		return nil, err
+	}
+
+	// Keys whose type changed since TYPE fail, they are left to readKey
+	var small []string
+	for i, key := range keys {
+		if lenCmdsRun[i] != nil && lenCmdsRun[i].err != nil {
+			continue
+		}
+		if lens[i] <= o.LargeKeyThreshold {
+			small = append(small, key)
+		}
+	}
+	return small, nil
+}
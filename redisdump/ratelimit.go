@@ -0,0 +1,45 @@
+package redisdump
+
+import (
+	"context"
+
+	radix "github.com/mediocregopher/radix/v3"
+	"golang.org/x/time/rate"
+)
+
+// newRateLimiter returns a limiter letting perSecond events happen per
+// second, shared by all the goroutines waiting for it. Its burst is a single
+// event, so time spent idle does not build up a burst of events.
+func newRateLimiter(perSecond float64) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(perSecond), 1)
+}
+
+// waitRate blocks until limiter lets the next event happen, or ctx is
+// cancelled. A nil limiter never blocks.
+func waitRate(ctx context.Context, limiter *rate.Limiter) error {
+	if limiter == nil {
+		return nil
+	}
+	return limiter.Wait(ctx)
+}
+
+// limitedClient is a radix.Client waiting for limiter before each action,
+// and before each command of pipelines. Waits stop when ctx is cancelled.
+type limitedClient struct {
+	radix.Client
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func (c limitedClient) Do(a radix.Action) error {
+	n := 1
+	if p, ok := a.(pipeline); ok {
+		n = max(len(p), 1)
+	}
+	for i := 0; i < n; i++ {
+		if err := c.limiter.Wait(c.ctx); err != nil {
+			return err
+		}
+	}
+	return c.Client.Do(a)
+}
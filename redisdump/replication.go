@@ -0,0 +1,31 @@
+package redisdump
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// waitReplicas sends WAIT to the server, for o.WaitReplicas replicas to
+// acknowledge within o.WaitTimeout. Fewer replicas acknowledging is warned
+// about, or fails with o.StrictWait.
+func waitReplicas(client radix.Client, o DumpOptions) error {
+	var acked int
+	timeout := strconv.FormatInt(int64(o.WaitTimeout/time.Millisecond), 10)
+	if err := client.Do(radix.Cmd(&acked, "WAIT", strconv.Itoa(o.WaitReplicas), timeout)); err != nil {
+		return fmt.Errorf("Error waiting for replicas: %s", err)
+	}
+	if acked >= o.WaitReplicas {
+		return nil
+	}
+
+	if o.StrictWait {
+		return fmt.Errorf("Error waiting for replicas: %d of %d replicas acknowledged within %s", acked, o.WaitReplicas, o.WaitTimeout)
+	}
+	if o.warnings == nil {
+		return nil
+	}
+	return o.warnings.WriteLine(fmt.Sprintf("Warning: only %d of %d replicas acknowledged within %s", acked, o.WaitReplicas, o.WaitTimeout))
+}
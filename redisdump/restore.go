@@ -0,0 +1,458 @@
+package redisdump
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	radix "github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+	"golang.org/x/sync/errgroup"
+)
+
+// RestoreOptions holds the optional settings of a restore
+type RestoreOptions struct {
+	// Network, Username, Password and TLSConfig configure connections to
+	// Redis, as for DumpOptions.
+	Network            string
+	Username, Password string
+	TLSConfig          *tls.Config
+
+	// FlushFirst empties the DB with FLUSHDB before restoring it.
+	FlushFirst bool
+
+	// IgnoreErrors carries on with the next command when Redis replies to a
+	// command with an error, rather than stopping the restore. Connection
+	// errors and malformed dumps still stop it.
+	IgnoreErrors bool
+
+	// KeyPattern, if not empty, is a glob-style pattern restricting the
+	// restore to the keys it matches.
+	KeyPattern string
+
+	// dial, if not nil, replaces the dialer establishing connections, for
+	// tests
+	dial radix.ConnFunc
+}
+
+// RestoreOption sets one of the RestoreOptions
+type RestoreOption func(*RestoreOptions)
+
+// WithRestoreConnection sets how to connect to Redis, see WithNetwork,
+// WithAuth and WithTLSConfig
+func WithRestoreConnection(network, username, password string, tlsConfig *tls.Config) RestoreOption {
+	return func(o *RestoreOptions) {
+		o.Network = network
+		o.Username = username
+		o.Password = password
+		o.TLSConfig = tlsConfig
+	}
+}
+
+// WithFlushFirst empties the DB before restoring it
+func WithFlushFirst() RestoreOption {
+	return func(o *RestoreOptions) {
+		o.FlushFirst = true
+	}
+}
+
+// WithIgnoreErrors does not stop the restore on commands failing
+func WithIgnoreErrors() RestoreOption {
+	return func(o *RestoreOptions) {
+		o.IgnoreErrors = true
+	}
+}
+
+// WithKeyPattern only restores keys matching the glob-style pattern
+func WithKeyPattern(pattern string) RestoreOption {
+	return func(o *RestoreOptions) {
+		o.KeyPattern = pattern
+	}
+}
+
+func newRestoreOptions(opts []RestoreOption) RestoreOptions {
+	var o RestoreOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.Network == "" {
+		o.Network = "tcp"
+	}
+
+	return o
+}
+
+func restoreConnFunc(o RestoreOptions) radix.ConnFunc {
+	dial := o.dial
+	if dial == nil {
+		dial = dialer(o.TLSConfig)
+	}
+	return withAuth(dial, o.Username, o.Password)
+}
+
+// readRESPCommand reads the next command from r, serialized by
+// RESPSerializer as an array of bulk strings. io.EOF is returned when r ends
+// between two commands.
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line != "" {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("Error parsing RESP array header %q", line)
+	}
+	n, err := strconv.Atoi(line[1 : len(line)-2])
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("Error parsing RESP array header %q", line)
+	}
+
+	cmd := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if !strings.HasPrefix(line, "$") || !strings.HasSuffix(line, "\r\n") {
+			return nil, fmt.Errorf("Error parsing RESP bulk string header %q", line)
+		}
+		size, err := strconv.Atoi(line[1 : len(line)-2])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("Error parsing RESP bulk string header %q", line)
+		}
+
+		// Bulk strings are read by length, they may contain \r\n themselves
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if string(arg[size:]) != "\r\n" {
+			return nil, fmt.Errorf("Error parsing RESP bulk string of length %d", size)
+		}
+		cmd = append(cmd, string(arg[:size]))
+	}
+
+	return cmd, nil
+}
+
+// readCommandLine reads the next command from r, serialized by
+// RedisCmdSerializer on its own line. Empty lines are skipped, io.EOF is
+// returned when r ends.
+func readCommandLine(r *bufio.Reader) ([]string, error) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			continue
+		}
+		return splitCommandLine(line)
+	}
+}
+
+// splitCommandLine splits line into the arguments of a command, unquoting
+// the arguments quoted by quoteArg
+func splitCommandLine(line string) ([]string, error) {
+	var args []string
+	for i := 0; i < len(line); {
+		if line[i] == ' ' {
+			i++
+			continue
+		}
+
+		if line[i] != '"' {
+			end := strings.IndexByte(line[i:], ' ')
+			if end < 0 {
+				end = len(line) - i
+			}
+			args = append(args, line[i:i+end])
+			i += end
+			continue
+		}
+
+		var arg strings.Builder
+		closed := false
+		for i++; i < len(line) && !closed; i++ {
+			switch c := line[i]; {
+			case c == '"':
+				closed = true
+			case c != '\\':
+				arg.WriteByte(c)
+			case i+1 == len(line):
+				return nil, fmt.Errorf("Error parsing command %q: unterminated escape", line)
+			default:
+				i++
+				switch line[i] {
+				case 'n':
+					arg.WriteByte('\n')
+				case 'r':
+					arg.WriteByte('\r')
+				case 't':
+					arg.WriteByte('\t')
+				case 'x':
+					if i+2 >= len(line) {
+						return nil, fmt.Errorf("Error parsing command %q: invalid escape", line)
+					}
+					b, err := strconv.ParseUint(line[i+1:i+3], 16, 8)
+					if err != nil {
+						return nil, fmt.Errorf("Error parsing command %q: invalid escape", line)
+					}
+					arg.WriteByte(byte(b))
+					i += 2
+				default:
+					arg.WriteByte(line[i])
+				}
+			}
+		}
+		if !closed {
+			return nil, fmt.Errorf("Error parsing command %q: unterminated quotes", line)
+		}
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// commandKey returns the key cmd operates on, for the commands generated by
+// the dump
+func commandKey(cmd []string) (string, bool) {
+	if len(cmd) >= 3 && strings.EqualFold(cmd[0], "XGROUP") {
+		return cmd[2], true
+	}
+	if len(cmd) >= 2 && !strings.EqualFold(cmd[0], "SELECT") {
+		return cmd[1], true
+	}
+	return "", false
+}
+
+// restoreCmds sends the commands read from r to client, one at a time
+func restoreCmds(client radix.Client, r io.Reader, o RestoreOptions) error {
+	br := bufio.NewReader(r)
+	for {
+		cmd, err := readRESPCommand(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(cmd) == 0 {
+			continue
+		}
+
+		// The connection already uses the DB being restored
+		if strings.EqualFold(cmd[0], "SELECT") {
+			continue
+		}
+		if skipCommand(cmd, o) {
+			continue
+		}
+
+		if err := restoreCmd(client, cmd, o); err != nil {
+			return err
+		}
+	}
+}
+
+// skipCommand reports whether cmd is left out of the restore by KeyPattern
+func skipCommand(cmd []string, o RestoreOptions) bool {
+	if o.KeyPattern == "" {
+		return false
+	}
+	key, ok := commandKey(cmd)
+	return ok && !matchGlob(o.KeyPattern, key)
+}
+
+// restoreCmd sends cmd to client. Errors replied by Redis are ignored with
+// IgnoreErrors.
+func restoreCmd(client radix.Client, cmd []string, o RestoreOptions) error {
+	if err := client.Do(radix.Cmd(nil, cmd[0], cmd[1:]...)); err != nil {
+		if _, ok := err.(resp2.Error); ok && o.IgnoreErrors {
+			return nil
+		}
+		return fmt.Errorf("Error restoring %s: %s", cmd[0], err)
+	}
+	return nil
+}
+
+// RestoreDB restores a dump in the Redis protocol, as written by DumpDB with
+// RESPSerializer, from r into the DB db of the redis server given by
+// redisURL. SELECT commands of the dump are skipped, all keys are restored
+// into db.
+func RestoreDB(redisURL string, db uint8, r io.Reader, opts ...RestoreOption) error {
+	o := newRestoreOptions(opts)
+
+	dial := withDBSelection(restoreConnFunc(o), db)
+	conn, err := dial(o.Network, redisURL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if o.FlushFirst {
+		if err = conn.Do(radix.Cmd(nil, "FLUSHDB")); err != nil {
+			return err
+		}
+	}
+
+	return restoreCmds(conn, r, o)
+}
+
+// dbCmd is a command of a dump, along with the DB it applies to
+type dbCmd struct {
+	db  uint8
+	cmd []string
+}
+
+// restoreWorker sends the commands read from cmds through its own
+// connection, selecting the DB of each command first when needed
+func restoreWorker(dial radix.ConnFunc, network, redisURL string, cmds <-chan dbCmd, pending *sync.WaitGroup, o RestoreOptions) error {
+	conn, err := dial(network, redisURL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var db uint8
+	for c := range cmds {
+		if c.db != db {
+			if err = conn.Do(radix.Cmd(nil, "SELECT", fmt.Sprint(c.db))); err != nil {
+				pending.Done()
+				return err
+			}
+			db = c.db
+		}
+
+		err = restoreCmd(conn, c.cmd, o)
+		pending.Done()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreFromReader restores a dump read from r, in the Redis protocol or as
+// commands, as written with RESPSerializer or RedisCmdSerializer, into the
+// redis server given by redisURL. Keys are restored into the DBs selected by
+// the SELECT commands of the dump, DB 0 until the first one.
+//
+// Commands are sent by nWorkers workers, each through its own connection.
+// All commands of a key are sent by the same worker, in the order of the
+// dump. Commands without a key, such as FLUSHDB, are only sent once all the
+// commands before them are done, and before any command after them.
+// Transactions, from MULTI to EXEC, are sent by a single worker, as a
+// command without a key would be.
+// FlushFirst is ignored, dumps made WithFlushDB already empty each DB.
+func RestoreFromReader(redisURL string, r io.Reader, nWorkers int, opts ...RestoreOption) error {
+	o := newRestoreOptions(opts)
+	dial := restoreConnFunc(o)
+	if nWorkers < 1 {
+		nWorkers = 1
+	}
+
+	br := bufio.NewReader(r)
+	readCommand := readCommandLine
+	if first, err := br.Peek(1); err == nil && first[0] == '*' {
+		readCommand = readRESPCommand
+	}
+
+	// The first worker to fail cancels gctx, which stops reading the dump
+	g, gctx := errgroup.WithContext(context.Background())
+	var pending sync.WaitGroup
+	workers := make([]chan dbCmd, nWorkers)
+	for i := range workers {
+		cmds := make(chan dbCmd)
+		workers[i] = cmds
+		g.Go(func() error {
+			return restoreWorker(dial, o.Network, redisURL, cmds, &pending, o)
+		})
+	}
+
+	send := func(worker int, c dbCmd) bool {
+		pending.Add(1)
+		select {
+		case workers[worker] <- c:
+			return true
+		case <-gctx.Done():
+			pending.Done()
+			return false
+		}
+	}
+
+	g.Go(func() error {
+		defer func() {
+			for _, cmds := range workers {
+				close(cmds)
+			}
+		}()
+
+		var db uint8
+		inTransaction := false
+		for {
+			cmd, err := readCommand(br)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if len(cmd) == 0 || skipCommand(cmd, o) {
+				continue
+			}
+
+			if strings.EqualFold(cmd[0], "SELECT") && len(cmd) == 2 {
+				n, err := strconv.ParseUint(cmd[1], 10, 8)
+				if err != nil {
+					return fmt.Errorf("Error parsing SELECT %s: %s", cmd[1], err)
+				}
+				db = uint8(n)
+				continue
+			}
+
+			if strings.EqualFold(cmd[0], "MULTI") {
+				pending.Wait()
+				inTransaction = true
+			}
+			if inTransaction {
+				if !send(0, dbCmd{db, cmd}) {
+					return nil
+				}
+				if strings.EqualFold(cmd[0], "EXEC") {
+					pending.Wait()
+					inTransaction = false
+				}
+				continue
+			}
+
+			key, ok := commandKey(cmd)
+			if !ok {
+				// Barrier: all the commands before cmd are done before it
+				pending.Wait()
+				if !send(0, dbCmd{db, cmd}) {
+					return nil
+				}
+				pending.Wait()
+				continue
+			}
+
+			h := fnv.New32a()
+			h.Write([]byte(key))
+			if !send(int(h.Sum32()%uint32(nWorkers)), dbCmd{db, cmd}) {
+				return nil
+			}
+		}
+	})
+
+	return g.Wait()
+}
@@ -0,0 +1,74 @@
+package redisdump
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// isConnError reports whether err is a failure of the connection to the
+// server, such as when it restarts, rather than an error replied by it
+func isConnError(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// retryClient is a radix.Client retrying actions failing with a connection
+// error, up to maxRetries times, waiting backoff before the first retry and
+// twice as long before each of the next ones. The pool it wraps replaces the
+// connections that failed with a network error; connections closed by the
+// server, which fail with io.EOF, are only replaced once writing to them
+// fails, so a retry may be spent on each of them.
+type retryClient struct {
+	radix.Client
+	maxRetries int
+	backoff    time.Duration
+	warnings   *lineWriter
+}
+
+func (c retryClient) Do(a radix.Action) error {
+	return retry(c.maxRetries, c.backoff, c.warnings, func() error {
+		return c.Client.Do(a)
+	})
+}
+
+// retry calls f until it succeeds, fails with an error other than a
+// connection error, or fails maxRetries+1 times, waiting backoff before the
+// first retry and twice as long before each of the next ones
+func retry(maxRetries int, backoff time.Duration, warnings *lineWriter, f func() error) error {
+	for retry := 1; ; retry++ {
+		err := f()
+		if err == nil || retry > maxRetries || !isConnError(err) {
+			return err
+		}
+
+		if warnings != nil {
+			if werr := warnings.WriteLine(fmt.Sprintf("Warning: retrying in %s after a connection error (%d/%d): %s", backoff, retry, maxRetries, err)); werr != nil {
+				return werr
+			}
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// withDialRetries retries opening connections failing with a connection
+// error, as retryClient does for commands, so that the pools and the
+// connections replacing the ones that failed survive a server restart
+func withDialRetries(dial radix.ConnFunc, maxRetries int, backoff time.Duration, warnings *lineWriter) radix.ConnFunc {
+	return func(network, addr string) (radix.Conn, error) {
+		var conn radix.Conn
+		err := retry(maxRetries, backoff, warnings, func() error {
+			var err error
+			conn, err = dial(network, addr)
+			return err
+		})
+		return conn, err
+	}
+}
@@ -0,0 +1,141 @@
+package redisdump
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// resolveMaster asks the Sentinels of o, one after the other, for the address
+// of the current master of o.MasterName, with SENTINEL
+// get-master-addr-by-name. Sentinels are connected to like the server, with
+// the TLS configuration and credentials of o.
+func resolveMaster(o DumpOptions) (string, error) {
+	dial := connFunc(o)
+
+	var err error
+	for _, sentinelURL := range o.SentinelURLs {
+		var addr string
+		if addr, err = askMaster(dial, sentinelURL, o.MasterName); err == nil {
+			return addr, nil
+		}
+	}
+	return "", fmt.Errorf("Error resolving master %s from Sentinels %v: %s", o.MasterName, o.SentinelURLs, err)
+}
+
+// askMaster asks the Sentinel at sentinelURL for the address of the master
+// masterName
+func askMaster(dial radix.ConnFunc, sentinelURL, masterName string) (string, error) {
+	conn, err := dial("tcp", sentinelURL)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	var hostPort []string
+	mn := radix.MaybeNil{Rcv: &hostPort}
+	if err = conn.Do(radix.Cmd(&mn, "SENTINEL", "get-master-addr-by-name", masterName)); err != nil {
+		return "", err
+	}
+	if mn.Nil || len(hostPort) != 2 {
+		return "", errors.New("unknown master")
+	}
+	return net.JoinHostPort(hostPort[0], hostPort[1]), nil
+}
+
+// serverURL returns the address of the server to dump: the UnixSocket of o,
+// the current master as known by its Sentinels, or redisURL. With
+// MaxRetries, dumpDB follows the master if it fails over during the dump.
+func serverURL(redisURL string, o DumpOptions) (string, error) {
+	if o.UnixSocket != "" {
+		return o.UnixSocket, nil
+	}
+	if len(o.SentinelURLs) > 0 {
+		return resolveMaster(o)
+	}
+	return redisURL, nil
+}
+
+// failoverClient is a radix.Client dumping the master of the Sentinels of o,
+// through a pool to its current address. When an action fails with a
+// connection error, the master is resolved again, and the pool replaced if
+// it failed over. The action still fails: it is retried on the new master by
+// a retryClient wrapping the failoverClient. Replaced pools are only closed
+// with the failoverClient, as actions may still be running on them.
+type failoverClient struct {
+	resolve  func() (string, error)
+	newPool  func(addr string) (radix.Client, error)
+	warnings *lineWriter
+
+	mu    sync.Mutex
+	addr  string
+	pool  radix.Client
+	pools []radix.Client
+}
+
+func newFailoverClient(addr string, resolve func() (string, error), newPool func(addr string) (radix.Client, error), warnings *lineWriter) (*failoverClient, error) {
+	pool, err := newPool(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &failoverClient{resolve: resolve, newPool: newPool, warnings: warnings, addr: addr, pool: pool, pools: []radix.Client{pool}}, nil
+}
+
+func (c *failoverClient) Do(a radix.Action) error {
+	c.mu.Lock()
+	pool := c.pool
+	c.mu.Unlock()
+
+	err := pool.Do(a)
+	if isConnError(err) {
+		if ferr := c.followFailover(pool); ferr != nil {
+			return ferr
+		}
+	}
+	return err
+}
+
+// followFailover resolves the master again after pool failed, unless it was
+// already replaced, and replaces pool if the master changed
+func (c *failoverClient) followFailover(pool radix.Client) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pool != pool {
+		return nil
+	}
+
+	// Sentinels may not have promoted a new master yet, the resolution is
+	// then tried again with the next retry
+	addr, err := c.resolve()
+	if err != nil || addr == c.addr {
+		return nil
+	}
+	if pool, err = c.newPool(addr); err != nil {
+		return nil
+	}
+
+	previous := c.addr
+	c.addr, c.pool = addr, pool
+	c.pools = append(c.pools, pool)
+
+	if c.warnings == nil {
+		return nil
+	}
+	return c.warnings.WriteLine(fmt.Sprintf("Warning: the master failed over from %s to %s, resuming the dump from it", previous, addr))
+}
+
+func (c *failoverClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var err error
+	for _, pool := range c.pools {
+		if cerr := pool.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
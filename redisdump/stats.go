@@ -0,0 +1,145 @@
+package redisdump
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+// DumpStats summarizes a dump, for callers to check that it dumped the
+// expected number of keys. It is returned by DumpDB, DumpServer,
+// DumpCluster, DumpToFile and DumpToUpload, even when they fail.
+type DumpStats struct {
+	// TotalKeys is the number of keys dumped, and ByType their number per
+	// type, such as "hash".
+	TotalKeys int
+	ByType    map[string]int
+
+	// SkippedKeys is the number of keys scanned but not dumped, because of
+	// ExcludeFilters, KeyRegex, ExcludeRegex, TypeFilter, ExcludeTypes,
+	// OnlyVolatile or OnlyPersistent, or because they were deleted since they
+	// were scanned, or expired with SkipExpired.
+	SkippedKeys int
+
+	// UnsupportedKeys is the number of keys skipped with
+	// SkipUnsupportedTypes, as they are of a type that can not be dumped.
+	// They are counted in SkippedKeys too.
+	UnsupportedKeys int
+
+	// TTLs is the number of keys dumped with an expiry.
+	TTLs int
+
+	// MemoryUsage is the number of bytes used by the keys, as estimated by
+	// MEMORY USAGE, and MinKeyBytes and MaxKeyBytes the usage of the
+	// smallest and largest key. They are only measured by dry runs, or with
+	// VerboseStats or TopLargestKeys.
+	MemoryUsage              int64
+	MinKeyBytes, MaxKeyBytes int64
+
+	// TopKeys are the largest keys, by memory usage, largest first. It
+	// holds up to TopLargestKeys keys.
+	TopKeys []KeySizePair
+
+	// BytesWritten is the number of bytes written to the output, after
+	// compression.
+	BytesWritten int64
+
+	// Errors is the number of keys that could not be dumped WithContinueOnError,
+	// or 1 if the dump stopped on an error.
+	Errors int
+
+	// Duration is the time spent dumping.
+	Duration time.Duration
+}
+
+// KeySizePair is a key of the DB DB and of type Type, using Bytes bytes
+type KeySizePair struct {
+	DB    uint8
+	Key   string
+	Type  string
+	Bytes int64
+}
+
+// keySizeHeap is a min-heap of keys by size, keeping the largest keys seen
+// while the smallest one is dropped
+type keySizeHeap []KeySizePair
+
+func (h keySizeHeap) Len() int            { return len(h) }
+func (h keySizeHeap) Less(i, j int) bool  { return h[i].Bytes < h[j].Bytes }
+func (h keySizeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *keySizeHeap) Push(x interface{}) { *h = append(*h, x.(KeySizePair)) }
+func (h *keySizeHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// addTopKey adds key to TopKeys if it is one of the n largest keys. TopKeys
+// is kept as a keySizeHeap until sortTopKeys is called.
+func (s *DumpStats) addTopKey(key KeySizePair, n int) {
+	h := (*keySizeHeap)(&s.TopKeys)
+	if h.Len() < n {
+		heap.Push(h, key)
+	} else if n > 0 && key.Bytes > s.TopKeys[0].Bytes {
+		s.TopKeys[0] = key
+		heap.Fix(h, 0)
+	}
+}
+
+// sortTopKeys sorts TopKeys, largest first
+func (s *DumpStats) sortTopKeys() {
+	sort.Slice(s.TopKeys, func(i, j int) bool {
+		return s.TopKeys[i].Bytes > s.TopKeys[j].Bytes
+	})
+}
+
+// addKey counts a key of type keyType, with an expiry if ttl is positive
+func (s *DumpStats) addKey(keyType string, ttl int64) {
+	if s.ByType == nil {
+		s.ByType = map[string]int{}
+	}
+	s.TotalKeys++
+	s.ByType[keyType]++
+	if ttl > 0 {
+		s.TTLs++
+	}
+}
+
+// addMemoryUsage counts a key using size bytes
+func (s *DumpStats) addMemoryUsage(size int64) {
+	if s.MaxKeyBytes == 0 || size < s.MinKeyBytes {
+		s.MinKeyBytes = size
+	}
+	if size > s.MaxKeyBytes {
+		s.MaxKeyBytes = size
+	}
+	s.MemoryUsage += size
+}
+
+// add adds the counts and elapsed time of other to s, keeping the topN
+// largest keys of both
+func (s *DumpStats) add(other DumpStats, topN int) {
+	if s.ByType == nil && len(other.ByType) > 0 {
+		s.ByType = map[string]int{}
+	}
+	s.TotalKeys += other.TotalKeys
+	for keyType, n := range other.ByType {
+		s.ByType[keyType] += n
+	}
+	s.SkippedKeys += other.SkippedKeys
+	s.UnsupportedKeys += other.UnsupportedKeys
+	s.TTLs += other.TTLs
+	s.BytesWritten += other.BytesWritten
+	if other.MaxKeyBytes > 0 && (s.MaxKeyBytes == 0 || other.MinKeyBytes < s.MinKeyBytes) {
+		s.MinKeyBytes = other.MinKeyBytes
+	}
+	if other.MaxKeyBytes > s.MaxKeyBytes {
+		s.MaxKeyBytes = other.MaxKeyBytes
+	}
+	s.MemoryUsage += other.MemoryUsage
+	for _, key := range other.TopKeys {
+		s.addTopKey(key, topN)
+	}
+	s.Duration += other.Duration
+}
@@ -0,0 +1,29 @@
+package redisdump
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// startSpan starts a span named name, with attributes, as a child of the span
+// of ctx if any. Without Tracer in o, the span does nothing.
+func startSpan(ctx context.Context, name string, o DumpOptions, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := o.Tracer
+	if tracer == nil {
+		tracer = noop.NewTracerProvider().Tracer("")
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// endSpan ends span, with the error the operation failed with, if any
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,29 @@
+package redisdump
+
+import (
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// TypeHandler dumps keys of types that are not supported by the package,
+// such as the types of Redis modules, or replaces how a supported type is
+// dumped. Handlers are registered with WithTypeHandler.
+type TypeHandler interface {
+	// CanHandle reports whether the handler dumps keys of type keyType, as
+	// returned by TYPE, such as "ReJSON-RL".
+	CanHandle(keyType string) bool
+
+	// Dump reads key from client and returns the commands restoring it. The
+	// TTL of key is dumped separately and must not be part of the commands.
+	Dump(client radix.Client, key string) ([][]string, error)
+}
+
+// typeHandler returns the first of handlers able to dump keys of type
+// keyType, or nil if there is none
+func typeHandler(handlers []TypeHandler, keyType string) TypeHandler {
+	for _, h := range handlers {
+		if h.CanHandle(keyType) {
+			return h
+		}
+	}
+	return nil
+}
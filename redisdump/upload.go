@@ -0,0 +1,194 @@
+package redisdump
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Upload is an output whose content is only kept once completed, such as a
+// multipart upload to an object store like S3. Complete keeps what was
+// written, Abort discards it. Abort is called if the dump fails, or if
+// Complete fails, so that nothing is left of a failed upload.
+type Upload interface {
+	io.Writer
+	Complete() error
+	Abort() error
+}
+
+// writeUpload runs dump with a buffered writer to upload, which is completed
+// if dump succeeds and aborted otherwise, unless the error is a DumpErrors:
+// the dump is then complete except for the keys that failed.
+func writeUpload(upload Upload, dump func(io.Writer) error) error {
+	bw := bufio.NewWriter(upload)
+	dumpErr := dump(bw)
+
+	var err error
+	if _, ok := dumpErr.(DumpErrors); ok || dumpErr == nil {
+		err = bw.Flush()
+	} else {
+		err = dumpErr
+	}
+	if err != nil {
+		upload.Abort()
+		return err
+	}
+	if err = upload.Complete(); err != nil {
+		upload.Abort()
+		return err
+	}
+
+	return dumpErr
+}
+
+// writeOutput starts an upload with output, and writes the dump to it as
+// writeUpload does
+func writeOutput(ctx context.Context, output func(context.Context) (Upload, error), dump func(io.Writer) error) error {
+	upload, err := output(ctx)
+	if err != nil {
+		return err
+	}
+	return writeUpload(upload, dump)
+}
+
+// DumpToUpload dumps the redis server given by redisURL to upload, as
+// DumpServer does. upload is completed once the dump is complete, and
+// aborted if the dump or its completion fails, so that it never holds a
+// partial dump. The errors of Abort are ignored, the error of the dump or of
+// Complete is returned.
+func DumpToUpload(ctx context.Context, redisURL string, nWorkers int, upload Upload, serializer func([]string) string, progress chan<- ProgressNotification, opts ...DumpOption) (DumpStats, error) {
+	var stats DumpStats
+	err := writeUpload(upload, func(w io.Writer) error {
+		var err error
+		stats, err = DumpServer(ctx, redisURL, nWorkers, w, serializer, progress, opts...)
+		return err
+	})
+	return stats, err
+}
+
+// s3PartSize is the size of the parts of S3 uploads. As an upload has at most
+// 10000 parts, dumps of up to 160 GiB can be uploaded.
+const s3PartSize = 16 << 20
+
+// s3Client is the part of the S3 client of the AWS SDK used by s3Upload
+type s3Client interface {
+	CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+}
+
+// s3Upload is an Upload to an S3 object, through a multipart upload. What is
+// written is buffered, and uploaded as a part once partSize bytes are
+// buffered. The object is only created once the upload is completed.
+type s3Upload struct {
+	ctx      context.Context
+	client   s3Client
+	bucket   string
+	key      string
+	uploadID *string
+	partSize int
+
+	buf   []byte
+	parts []types.CompletedPart
+}
+
+func newS3Upload(ctx context.Context, client s3Client, bucket, key string, partSize int) (*s3Upload, error) {
+	out, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error starting upload to s3://%s/%s: %s", bucket, key, err)
+	}
+	return &s3Upload{ctx: ctx, client: client, bucket: bucket, key: key, uploadID: out.UploadId, partSize: partSize}, nil
+}
+
+func (u *s3Upload) Write(p []byte) (int, error) {
+	u.buf = append(u.buf, p...)
+	if len(u.buf) >= u.partSize {
+		if err := u.uploadPart(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// uploadPart uploads what is buffered as the next part
+func (u *s3Upload) uploadPart() error {
+	partNumber := int32(len(u.parts) + 1)
+	out, err := u.client.UploadPart(u.ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(u.bucket),
+		Key:        aws.String(u.key),
+		UploadId:   u.uploadID,
+		PartNumber: aws.Int32(partNumber),
+		Body:       bytes.NewReader(u.buf),
+	})
+	if err != nil {
+		return fmt.Errorf("Error uploading part %d to s3://%s/%s: %s", partNumber, u.bucket, u.key, err)
+	}
+
+	u.parts = append(u.parts, types.CompletedPart{
+		PartNumber:        aws.Int32(partNumber),
+		ETag:              out.ETag,
+		ChecksumCRC32:     out.ChecksumCRC32,
+		ChecksumCRC32C:    out.ChecksumCRC32C,
+		ChecksumCRC64NVME: out.ChecksumCRC64NVME,
+		ChecksumSHA1:      out.ChecksumSHA1,
+		ChecksumSHA256:    out.ChecksumSHA256,
+	})
+	u.buf = u.buf[:0]
+	return nil
+}
+
+// Complete uploads what is left as the last part, which may be empty if it
+// is the only one, and creates the object from the parts
+func (u *s3Upload) Complete() error {
+	if len(u.buf) > 0 || len(u.parts) == 0 {
+		if err := u.uploadPart(); err != nil {
+			return err
+		}
+	}
+
+	_, err := u.client.CompleteMultipartUpload(u.ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(u.bucket),
+		Key:             aws.String(u.key),
+		UploadId:        u.uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: u.parts},
+	})
+	if err != nil {
+		return fmt.Errorf("Error completing upload to s3://%s/%s: %s", u.bucket, u.key, err)
+	}
+	return nil
+}
+
+// Abort deletes the parts uploaded. It is not given the context of the dump,
+// which may have been cancelled, so that the parts are not left to be billed.
+func (u *s3Upload) Abort() error {
+	_, err := u.client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(u.bucket),
+		Key:      aws.String(u.key),
+		UploadId: u.uploadID,
+	})
+	return err
+}
+
+// S3Output writes the dump to the object key of bucket, through a multipart
+// upload with the S3 client of cfg, rather than to w, see Output
+func S3Output(bucket, key string, cfg aws.Config) DumpOption {
+	return s3Output(s3.NewFromConfig(cfg), bucket, key, s3PartSize)
+}
+
+func s3Output(client s3Client, bucket, key string, partSize int) DumpOption {
+	return func(o *DumpOptions) {
+		o.Output = func(ctx context.Context) (Upload, error) {
+			return newS3Upload(ctx, client, bucket, key, partSize)
+		}
+	}
+}
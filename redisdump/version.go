@@ -0,0 +1,99 @@
+package redisdump
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	radix "github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// redisVersion is a Redis version, such as 7.0.11
+type redisVersion [3]int
+
+// parseRedisVersion reads the redis_version field of the reply to INFO
+// server
+func parseRedisVersion(info string) (redisVersion, error) {
+	var v redisVersion
+	for _, line := range strings.Split(info, "\n") {
+		if !strings.HasPrefix(line, "redis_version:") {
+			continue
+		}
+
+		version := strings.TrimSpace(strings.TrimPrefix(line, "redis_version:"))
+		parts := strings.SplitN(version, ".", 3)
+		for i, part := range parts {
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return v, fmt.Errorf("Error parsing Redis version %s", version)
+			}
+			v[i] = n
+		}
+		return v, nil
+	}
+	return v, fmt.Errorf("Error parsing Redis version: no redis_version in INFO server")
+}
+
+func (v redisVersion) atLeast(major, minor int) bool {
+	return v[0] > major || (v[0] == major && v[1] >= minor)
+}
+
+func (v redisVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+// checkServerVersion fails dumps of servers too old for the commands they
+// require, and warns about the versions the dump can be restored into. The
+// check is skipped with a warning when INFO is not available, as on some
+// managed services.
+func checkServerVersion(client radix.Client, o DumpOptions) error {
+	warn := func(format string, args ...interface{}) error {
+		if o.warnings == nil {
+			return nil
+		}
+		return o.warnings.WriteLine(fmt.Sprintf("Warning: "+format, args...))
+	}
+
+	var info string
+	if err := client.Do(radix.Cmd(&info, "INFO", "server")); err != nil {
+		if _, ok := err.(resp2.Error); ok {
+			return warn("the version of the server could not be checked: %s", err)
+		}
+		return err
+	}
+	v, err := parseRedisVersion(info)
+	if err != nil {
+		return warn("the version of the server could not be checked: %s", err)
+	}
+
+	switch {
+	case !v.atLeast(2, 8):
+		return fmt.Errorf("Redis %s is not supported, SCAN requires Redis 2.8", v)
+	case o.AbsoluteTTL && !v.atLeast(7, 0):
+		return fmt.Errorf("Redis %s is not supported with AbsoluteTTL, PEXPIRETIME requires Redis 7.0", v)
+	}
+
+	if (o.UseDumpRestore || o.HyperLogLogRestore) && !o.DryRun {
+		return warn("keys are dumped with DUMP from Redis %s, they can only be restored into Redis %d.%d or newer", v, v[0], v[1])
+	}
+	return nil
+}
+
+// checkServer checks the version of the server at redisURL, see
+// checkServerVersion, then waits for its replicas with WaitReplicas
+func checkServer(redisURL string, o DumpOptions) error {
+	conn, err := connFunc(o)(o.Network, redisURL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err = checkServerVersion(conn, o); err != nil {
+		return err
+	}
+	if o.WaitReplicas > 0 {
+		return waitReplicas(conn, o)
+	}
+	return nil
+}
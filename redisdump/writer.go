@@ -0,0 +1,194 @@
+package redisdump
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// lineWriter writes serialized keys and commands to an io.Writer, one per
+// line. It is safe for concurrent use by the dump workers: lines are never
+// split, and the lines of a key written with WriteLines are not interleaved
+// with the lines of other workers.
+type lineWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	written int64
+}
+
+func newLineWriter(w io.Writer) *lineWriter {
+	return &lineWriter{w: w}
+}
+
+// WriteLine writes s, followed by a newline unless s already ends with one,
+// such as commands serialized to RESP
+func (lw *lineWriter) WriteLine(s string) error {
+	if !strings.HasSuffix(s, "\n") {
+		s += "\n"
+	}
+
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	n, err := io.WriteString(lw.w, s)
+	lw.written += int64(n)
+	return err
+}
+
+// WriteLines writes lines as WriteLine does, all at once
+func (lw *lineWriter) WriteLines(lines []string) error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	for _, s := range lines {
+		if !strings.HasSuffix(s, "\n") {
+			s += "\n"
+		}
+		n, err := io.WriteString(lw.w, s)
+		lw.written += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Written returns the number of bytes written so far
+func (lw *lineWriter) Written() int64 {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	return lw.written
+}
+
+// lineBuffer is an io.Writer keeping the lines written by a lineWriter,
+// which writes each line with a single Write
+type lineBuffer []string
+
+func (b *lineBuffer) Write(p []byte) (int, error) {
+	*b = append(*b, string(p))
+	return len(p), nil
+}
+
+// transaction collects the lines of keys, and writes them to out wrapped in
+// MULTI and EXEC once maxCommands commands are collected, or on flush, so
+// that transactions are never written in part. Transactions hold whole keys:
+// a key of more than maxCommands commands gets a transaction of its own.
+type transaction struct {
+	out         *lineWriter
+	multi, exec string
+	maxCommands int
+	lines       []string
+}
+
+func newTransaction(out *lineWriter, serializer func([]string) string, maxCommands int) *transaction {
+	return &transaction{
+		out:         out,
+		multi:       serializer([]string{"MULTI"}),
+		exec:        serializer([]string{"EXEC"}),
+		maxCommands: maxCommands,
+	}
+}
+
+// add adds the lines of a key to the transaction, writing the transaction
+// first if they do not fit in it
+func (t *transaction) add(lines []string) error {
+	if len(t.lines) > 0 && len(t.lines)+len(lines) > t.maxCommands {
+		if err := t.flush(); err != nil {
+			return err
+		}
+	}
+	t.lines = append(t.lines, lines...)
+	return nil
+}
+
+// flush writes the transaction, if it holds any key
+func (t *transaction) flush() error {
+	if len(t.lines) == 0 {
+		return nil
+	}
+
+	lines := make([]string, 0, len(t.lines)+2)
+	lines = append(lines, t.multi)
+	lines = append(lines, t.lines...)
+	lines = append(lines, t.exec)
+	t.lines = t.lines[:0]
+	return t.out.WriteLines(lines)
+}
+
+// countingWriter counts the bytes written to w, and reports them to metrics
+// if not nil
+type countingWriter struct {
+	w       io.Writer
+	written int64
+	metrics Metrics
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.written += int64(n)
+	if cw.metrics != nil {
+		cw.metrics.BytesWritten(n)
+	}
+	return n, err
+}
+
+// withCompression runs dump with a writer compressing its output to w with
+// compression, "gzip" or "" for none. The compressed stream is completed once
+// dump returns, even if it fails, so that what was dumped can be read back.
+func withCompression(w io.Writer, compression string, dump func(io.Writer) error) error {
+	switch compression {
+	case "":
+		return dump(w)
+
+	case "gzip":
+		gw := gzip.NewWriter(w)
+		err := dump(gw)
+		if closeErr := gw.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+
+	default:
+		return fmt.Errorf("Unsupported compression %s", compression)
+	}
+}
+
+// writeFileAtomically runs dump with a buffered writer to path.tmp, which is
+// then renamed to path, so that path never holds a partial dump. The
+// temporary file is removed if dump fails, unless the error is a DumpErrors:
+// the dump is then complete except for the keys that failed.
+func writeFileAtomically(path string, dump func(io.Writer) error) error {
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(f)
+	dumpErr := dump(bw)
+	if _, ok := dumpErr.(DumpErrors); ok || dumpErr == nil {
+		err = bw.Flush()
+		if err == nil {
+			err = f.Sync()
+		}
+	} else {
+		err = dumpErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return dumpErr
+}